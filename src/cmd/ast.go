@@ -2,11 +2,12 @@ package main
 
 import (
   "fmt"
-  "path"
   "strings"
   "strconv"
   "go/ast"
   "go/token"
+  "go/types"
+  "go/scanner"
 )
 
 type ident struct {
@@ -84,6 +85,12 @@ func parseIdentR(e ast.Expr, r, d int) (*ident, error) {
       }
       return parseIdentR(v.Elt, r, d + 1)
       
+    case *ast.IndexExpr:
+      return instanceIdent(v.X, []ast.Expr{v.Index}, r, d)
+      
+    case *ast.IndexListExpr:
+      return instanceIdent(v.X, v.Indices, r, d)
+      
     case *ast.MapType:
       key, err := parseIdentE(v.Key, true)
       if err != nil {
@@ -101,6 +108,78 @@ func parseIdentR(e ast.Expr, r, d int) (*ident, error) {
   }
 }
 
+/**
+ * An instantiated generic type, e.g., Page[User], which is named for its
+ * type arguments: PageOfUser
+ */
+func instanceIdent(x ast.Expr, args []ast.Expr, r, d int) (*ident, error) {
+  g, err := parseIdentR(x, r, d)
+  if err != nil {
+    return nil, err
+  }
+  if g.Inds != r || g.Dims != d || g.Key != nil {
+    return nil, fmt.Errorf("Not a valid generic type: %v", types.ExprString(x))
+  }
+  
+  names := make([]string, len(args))
+  bases := make([]string, len(args))
+  for i, e := range args {
+    a, err := parseIdentE(e, true)
+    if err != nil {
+      return nil, err
+    }
+    names[i] = types.ExprString(e)
+    bases[i] = strings.Title(a.Base)
+  }
+  
+  return newIdent(g.Name +"["+ strings.Join(names, ", ") +"]", g.Base +"Of"+ strings.Join(bases, "And"), r, d), nil
+}
+
+/**
+ * Produce the type of a method receiver for a type declaration, which
+ * includes its type parameters if it's generic
+ */
+func recvType(spec *ast.TypeSpec) string {
+  if spec.TypeParams == nil || len(spec.TypeParams.List) < 1 {
+    return spec.Name.Name
+  }
+  var names []string
+  for _, e := range spec.TypeParams.List {
+    for _, n := range e.Names {
+      names = append(names, n.Name)
+    }
+  }
+  return spec.Name.Name +"["+ strings.Join(names, ", ") +"]"
+}
+
+/**
+ * Find the first of a set of type parameters an expression refers to
+ */
+func typeParamRef(e ast.Expr, params *ast.FieldList) (string, bool) {
+  if params == nil {
+    return "", false
+  }
+  names := make(map[string]struct{})
+  for _, f := range params.List {
+    for _, n := range f.Names {
+      names[n.Name] = struct{}{}
+    }
+  }
+  var found string
+  ast.Inspect(e, func(n ast.Node) bool {
+    switch v := n.(type) {
+      case *ast.SelectorExpr:
+        return false // qualified; not a parameter
+      case *ast.Ident:
+        if _, ok := names[v.Name]; ok && found == "" {
+          found = v.Name
+        }
+    }
+    return found == ""
+  })
+  return found, found != ""
+}
+
 func concatIdent(e ast.Expr, r int) (string, string, int, error) {
   switch v := e.(type) {
     
@@ -128,6 +207,39 @@ func concatIdent(e ast.Expr, r int) (string, string, int, error) {
   }
 }
 
+/**
+ * The anonymous struct a field declares inline, either directly or through a
+ * single pointer
+ */
+func anonStruct(e ast.Expr) (*ast.StructType, bool, bool) {
+  if v, ok := e.(*ast.StarExpr); ok {
+    s, ok := v.X.(*ast.StructType)
+    return s, true, ok
+  }
+  s, ok := e.(*ast.StructType)
+  return s, false, ok
+}
+
+/**
+ * Find a ref field that is declared somewhere it cannot be supported: in a
+ * function body, a variable declaration, or an anonymous struct that is not
+ * itself the type of a field. Ref types and marshalers are declared at package
+ * scope, so the struct that holds a ref field must be reachable from there.
+ */
+func checkRefScope(fset *token.FileSet, n ast.Node) error {
+  var err error
+  ast.Inspect(n, func(n ast.Node) bool {
+    if f, ok := n.(*ast.Field); ok && isRefField(f) {
+      err = scanner.Error{
+        Pos: fset.Position(f.Pos()),
+        Msg: "Ref fields are only supported in struct types declared at package scope and the anonymous structs their fields declare",
+      }
+    }
+    return err == nil
+  })
+  return err
+}
+
 func leftmost(e *ast.SelectorExpr) ast.Expr {
   if v, ok := e.X.(*ast.SelectorExpr); ok {
     return leftmost(v)
@@ -160,7 +272,7 @@ func importPackage(e *ast.ImportSpec) string {
   if id := e.Name; id != nil {
     return id.Name
   }else{
-    return path.Base(stringLit(e.Path))
+    return packageName(stringLit(e.Path))
   }
 }
 
@@ -3,6 +3,8 @@ package main
 import (
   "fmt"
   "testing"
+  "go/ast"
+  "go/token"
   "go/parser"
   "github.com/stretchr/testify/assert"
 )
@@ -41,4 +43,49 @@ func TestParseIdent(t *testing.T) {
   testIdent(t, testCase{`map[string]*json.RawMessage`, &ident{`map[string]*json.RawMessage`, `MapOfStringToPtrToRawMessage`, 0, 0, newIdent(`string`, `string`, 0, 0)}})
   testIdent(t, testCase{`map[string][]time.Time`, &ident{`map[string][]time.Time`, `MapOfStringToArrayOfTime`, 0, 0, newIdent(`string`, `string`, 0, 0)}})
   testIdent(t, testCase{`map[string][]*time.Time`, &ident{`map[string][]*time.Time`, `MapOfStringToArrayOfPtrToTime`, 0, 0, newIdent(`string`, `string`, 0, 0)}})
+  testIdent(t, testCase{`Page[User]`, newIdent(`Page[User]`, `PageOfUser`, 0, 0)})
+  testIdent(t, testCase{`[]Page[User]`, newIdent(`[]Page[User]`, `ArrayOfPageOfUser`, 0, 1)})
+  testIdent(t, testCase{`*Pair[string, *users.User]`, newIdent(`*Pair[string, *users.User]`, `PairOfStringAndPtrToUser`, 1, 0)})
+  testIdent(t, testCase{`paging.Page[[]int]`, newIdent(`paging.Page[[]int]`, `PageOfArrayOfInt`, 0, 0)})
+}
+
+func TestCheckRefScope(t *testing.T) {
+  src := `package p
+type A struct {
+  B struct {
+    C *User "ref:\"c_id\""
+  }
+}
+type D []struct {
+  E *User "ref:\"e_id\""
+}
+func f() {
+  type G struct {
+    H *User "ref:\"h_id\""
+  }
+}
+`
+  fset := token.NewFileSet()
+  file, err := parser.ParseFile(fset, "p.go", src, 0)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  // the anonymous struct a field declares is marshaled along with it...
+  f := file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List[0]
+  a, ptr, ok := anonStruct(f.Type)
+  if assert.True(t, ok) {
+    assert.False(t, ptr)
+    assert.True(t, hasRefFields(a))
+  }
+  
+  // ...but one in a slice can't be, nor can a function-local type
+  err = checkRefScope(fset, file.Decls[1])
+  if assert.NotNil(t, err) {
+    assert.Contains(t, err.Error(), "p.go:8:3: ")
+  }
+  err = checkRefScope(fset, file.Decls[2])
+  if assert.NotNil(t, err) {
+    assert.Contains(t, err.Error(), "p.go:12:5: ")
+  }
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+  "io"
+  "fmt"
+  "strings"
+)
+
+/**
+ * The unexported type that holds a cached value for a ref type
+ */
+func cacheEntryName(n string) string {
+  return strings.ToLower(n[:1]) + n[1:] + cacheSuffix +"Entry"
+}
+
+/**
+ * Generate the cache for a ref type, which sits in front of any loader for
+ * it: a resolver's, or one of the application's. Values are kept in an LRU
+ * list with a TTL, ids the loader reports missing are remembered for their
+ * own TTL, and concurrent loads of the same id are combined into one.
+ */
+func genCache(cxt *context, w io.Writer, ref *refType) error {
+  if !cacheRefs {
+    return nil
+  }
+  
+  id := ref.Ident
+  var inds int
+  if !id.Nullable() {
+    inds++
+  }
+  
+  refId := ref.Name
+  vtype := repeat(inds, '*') + id.Name
+  rspec := fmt.Sprintf(`
+// %[1]v%[4]v keeps the values a %[1]v%[5]v loads in memory, so an id that is
+// used often is loaded once instead of every time. No more than Size values
+// are kept, unless it is zero, and the least recently used are evicted first.
+// Values expire after TTL, unless it is zero. Ids the loader reports missing,
+// with a nil value or an error that NotFound recognizes, are remembered for
+// NegativeTTL, and not at all if it is zero; other errors are never kept.
+// Concurrent loads of the same id share a single call to the loader, and its
+// outcome. A cache is safe for use by multiple goroutines.
+type %[1]v%[4]v struct {
+  Size        int
+  TTL         ref_time.Duration
+  NegativeTTL ref_time.Duration
+  NotFound    func(error) bool
+  
+  loader      %[1]v%[5]v
+  mu          ref_sync.Mutex
+  entries     map[%[2]v]*%[6]v
+  lru         *ref_list.List
+  stats       %[1]v%[4]vStats
+}
+
+// %[1]v%[4]vStats counts how a %[1]v%[4]v has served loads
+type %[1]v%[4]vStats struct {
+  Hits      uint64 // served from the cache, including ids known to be missing
+  Misses    uint64 // passed on to the loader
+  Shared    uint64 // waited for a load of the same id that was under way
+  Evictions uint64 // removed to keep no more than Size values
+  Size      int    // currently cached
+}
+
+type %[6]v struct {
+  id          %[2]v
+  val         %[3]v
+  err         error
+  expires     ref_time.Time
+  elem        *ref_list.Element
+  done        chan struct{}
+}
+
+func New%[1]v%[4]v(l %[1]v%[5]v, size int, ttl ref_time.Duration) *%[1]v%[4]v {
+  return &%[1]v%[4]v{Size:size, TTL:ttl, loader:l}
+}
+
+// Load returns the value of an id from the cache, or from the loader if it
+// isn't cached or has expired. It has the signature of a %[1]v%[5]v.
+func (c *%[1]v%[4]v) Load(ctx ref_context.Context, id %[2]v) (%[3]v, error) {
+  c.mu.Lock()
+  if c.entries == nil {
+    c.entries = make(map[%[2]v]*%[6]v)
+    c.lru = ref_list.New()
+  }
+  if e, ok := c.entries[id]; ok {
+    if done := e.done; done != nil {
+      c.stats.Shared++
+      c.mu.Unlock()
+      select {
+        case <-done:
+          return e.val, e.err
+        case <-ctx.Done():
+          return nil, ctx.Err()
+      }
+    }
+    if e.expires.IsZero() || ref_time.Now().Before(e.expires) {
+      c.stats.Hits++
+      c.lru.MoveToFront(e.elem)
+      c.mu.Unlock()
+      return e.val, e.err
+    }
+    c.remove(e)
+  }
+  
+  // the error stands if the loader never returns, so a panic is not cached
+  e := &%[6]v{id:id, done:make(chan struct{})}
+  e.err = ref_fmt.Errorf("Loading %[1]v %%v did not complete", id)
+  c.entries[id] = e
+  c.stats.Misses++
+  c.mu.Unlock()
+  
+  defer c.store(e)
+  e.val, e.err = c.loader(ctx, id)
+  return e.val, e.err
+}
+
+// Loader returns Load as a %[1]v%[5]v, e.g., to attach to refs
+func (c *%[1]v%[4]v) Loader() %[1]v%[5]v {
+  return c.Load
+}
+
+func (c *%[1]v%[4]v) store(e *%[6]v) {
+  missing := e.err == nil && e.val == nil
+  if e.err != nil && c.NotFound != nil {
+    missing = c.NotFound(e.err)
+  }
+  
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  done := e.done
+  e.done = nil
+  defer close(done)
+  
+  ttl := c.TTL
+  if missing {
+    ttl = c.NegativeTTL
+  }
+  if c.entries[e.id] != e || (e.err != nil && !missing) || (missing && ttl <= 0) {
+    if c.entries[e.id] == e {
+      delete(c.entries, e.id)
+    }
+    return // invalidated while loading, or not to be kept
+  }
+  if ttl > 0 {
+    e.expires = ref_time.Now().Add(ttl)
+  }
+  e.elem = c.lru.PushFront(e)
+  for c.Size > 0 && c.lru.Len() > c.Size {
+    c.remove(c.lru.Back().Value.(*%[6]v))
+    c.stats.Evictions++
+  }
+}
+
+func (c *%[1]v%[4]v) remove(e *%[6]v) {
+  delete(c.entries, e.id)
+  if e.elem != nil {
+    c.lru.Remove(e.elem)
+  }
+}
+
+// Invalidate removes ids from the cache, so they are loaded again on next
+// use. A load that is under way for one of them is not kept.
+func (c *%[1]v%[4]v) Invalidate(ids ...%[2]v) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  for _, id := range ids {
+    if e, ok := c.entries[id]; ok {
+      c.remove(e)
+    }
+  }
+}
+
+// Purge removes every id from the cache
+func (c *%[1]v%[4]v) Purge() {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.entries = nil
+  c.lru = nil
+}
+
+// Stats returns the counts since the cache was created
+func (c *%[1]v%[4]v) Stats() %[1]v%[4]vStats {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  s := c.stats
+  if c.lru != nil {
+    s.Size = c.lru.Len()
+  }
+  return s
+}`,
+  refId, idType, vtype, cacheSuffix, loaderSuffix, cacheEntryName(refId))
+  
+  fmt.Fprint(w, "\n"+ strings.TrimSpace(rspec) +"\n")
+  return nil
+}
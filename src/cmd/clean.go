@@ -0,0 +1,95 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "path"
+  "strings"
+  "path/filepath"
+)
+
+/**
+ * Remove the files we generated from a package directory. Generated files
+ * are identified by their header, never by their name alone, so a source
+ * file which happens to end with our suffix is left alone.
+ */
+func cleanDir(dir string) error {
+  entries, err := os.ReadDir(dir)
+  if err != nil {
+    return err
+  }
+  
+  for _, e := range entries {
+    if e.IsDir() || !strings.HasSuffix(e.Name(), ".go") {
+      continue
+    }
+    
+    p := path.Join(dir, e.Name())
+    gen, err := isFileGenerated(p)
+    if err != nil {
+      return err
+    }
+    if !gen {
+      if VERBOSE && strings.HasSuffix(e.Name(), fileSuffix +".go") {
+        fmt.Printf("%v: not generated by us; leaving: %v\n", CMD, p)
+      }
+      continue
+    }
+    
+    if DEBUG {
+      fmt.Printf("%v: would remove: %v\n", CMD, p)
+      continue
+    }
+    err = os.Remove(p)
+    if err != nil {
+      return err
+    }
+    fmt.Printf("%v: removed: %v\n", CMD, p)
+  }
+  
+  if overlayDir != "" {
+    return cleanOverlay(dir)
+  }
+  return nil
+}
+
+/**
+ * Remove the overlay copies for files in a package directory along with
+ * their entries in the overlay
+ */
+func cleanOverlay(dir string) error {
+  o, err := currentOverlay()
+  if err != nil {
+    return err
+  }
+  abs, err := filepath.Abs(dir)
+  if err != nil {
+    return err
+  }
+  
+  for k, v := range o.Replace {
+    if filepath.Dir(k) != abs {
+      continue
+    }
+    gen, err := isFileGenerated(v)
+    if err != nil && !os.IsNotExist(err) {
+      return err
+    }
+    if gen {
+      if DEBUG {
+        fmt.Printf("%v: would remove: %v\n", CMD, v)
+        continue
+      }
+      err = os.Remove(v)
+      if err != nil {
+        return err
+      }
+      fmt.Printf("%v: removed: %v\n", CMD, v)
+    }
+  }
+  
+  if DEBUG {
+    return nil
+  }
+  return overlayReset(dir)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+const cleanSource = `//go:build ignore
+
+package a
+
+type User struct {
+  Id      string    `+"`json:\"id\"`"+`
+}
+
+type Post struct {
+  Author  *User     `+"`json:\"author\" ref:\"author_id\"`"+`
+}
+`
+
+func TestCleanDir(t *testing.T) {
+  dir := writePackage(t, map[string]string{
+    "a.go":         cleanSource,
+    "notes_ref.go": "// Written by hand; it only looks generated.\npackage a\n\nconst Notes = 1\n",
+  })
+  applySettings(t, settings{})
+  
+  err := procDir(dir, optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  for _, e := range []string{"a_ref.go", pkgSrc + fileSuffix +".go"} {
+    gen, err := isFileGenerated(filepath.Join(dir, e))
+    assert.Nil(t, err, fmt.Sprintf("%v", err))
+    assert.True(t, gen, e)
+  }
+  
+  err = cleanDir(dir)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  entries, err := os.ReadDir(dir)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    var names []string
+    for _, e := range entries {
+      names = append(names, e.Name())
+    }
+    assert.Equal(t, []string{"a.go", "notes_ref.go"}, names)
+  }
+}
+
+func TestIsFileGenerated(t *testing.T) {
+  dir := writePackage(t, map[string]string{
+    "header.go":    "// "+ generatedHeader +". Changes will be overwritten.\npackage a\n",
+    "tagged.go":    "//go:build refgen\n\n// "+ generatedHeader +". Changes will be overwritten.\npackage a\n",
+    "later.go":     "package a\n\n// "+ generatedHeader +".\n",
+    "plain.go":     "// Package a does things.\npackage a\n",
+  })
+  for k, v := range map[string]bool{"header.go":true, "tagged.go":true, "later.go":false, "plain.go":false} {
+    gen, err := isFileGenerated(filepath.Join(dir, k))
+    if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+      assert.Equal(t, v, gen, k)
+    }
+  }
+}
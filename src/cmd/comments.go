@@ -0,0 +1,54 @@
+package main
+
+import (
+  "strings"
+  "go/ast"
+  "go/token"
+  "go/build/constraint"
+)
+
+/**
+ * Determine whether a comment is a directive that only concerns the original
+ * source: a build constraint, a goref macro or a //goref: directive. Copies
+ * are built under their own constraints and have already been processed, so
+ * these don't belong in them.
+ */
+func isSourceDirective(c *ast.Comment) bool {
+  if constraint.IsGoBuild(c.Text) || constraint.IsPlusBuild(c.Text) || strings.HasPrefix(c.Text, "//goref:") {
+    return true
+  }
+  m, _ := args(commentText(c))
+  return m == macro
+}
+
+/**
+ * Remove source directives from a file while keeping everything else, in
+ * particular the doc comments on types, fields and functions that go doc and
+ * editors display for the copy. Comments are associated with the nodes they
+ * document, so a group that consisted only of directives is dropped along
+ * with its association instead of leaving an empty group behind.
+ */
+func stripDirectives(fset *token.FileSet, file *ast.File) {
+  cmap := ast.NewCommentMap(fset, file, file.Comments)
+  for node, groups := range cmap {
+    var keep []*ast.CommentGroup
+    for _, g := range groups {
+      var list []*ast.Comment
+      for _, e := range g.List {
+        if !isSourceDirective(e) {
+          list = append(list, e)
+        }
+      }
+      if len(list) > 0 {
+        g.List = list
+        keep = append(keep, g)
+      }
+    }
+    if len(keep) > 0 {
+      cmap[node] = keep
+    }else{
+      delete(cmap, node)
+    }
+  }
+  file.Comments = cmap.Comments()
+}
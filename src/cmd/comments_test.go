@@ -0,0 +1,60 @@
+package main
+
+import (
+  "bytes"
+  "testing"
+  "go/token"
+  "go/parser"
+  "go/printer"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestStripDirectives(t *testing.T) {
+  fset := token.NewFileSet()
+  file, err := parser.ParseFile(fset, "a.go", `//go:build ignore
+// +build ignore
+
+// +goref ignore
+
+// Package a has posts.
+package a
+
+//goref:generate
+// Post is something written.
+//
+// It has an author.
+type Post struct {
+  // Author wrote it.
+  Author *User `+"`json:\"author\" ref:\"author_id\"`"+`
+  Title  string // what it's called
+  Body   string //goref:field
+}
+
+//goref:only
+type User struct{}
+`, parser.ParseComments)
+  if !assert.Nil(t, err) {
+    return
+  }
+  
+  stripDirectives(fset, file)
+  var out bytes.Buffer
+  err = printer.Fprint(&out, fset, file)
+  if assert.Nil(t, err) {
+    assert.Equal(t, `// Package a has posts.
+package a
+
+// Post is something written.
+//
+// It has an author.
+type Post struct {
+	// Author wrote it.
+	Author	*User	`+"`json:\"author\" ref:\"author_id\"`"+`
+	Title	string	// what it's called
+	Body	string
+}
+
+type User struct{}
+`, out.String())
+  }
+}
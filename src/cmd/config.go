@@ -0,0 +1,499 @@
+package main
+
+import (
+  "os"
+  "io"
+  "fmt"
+  "flag"
+  "strings"
+  "strconv"
+  "path/filepath"
+  "go/ast"
+  "go/token"
+  "gopkg.in/yaml.v3"
+  "github.com/BurntSushi/toml"
+)
+
+/**
+ * Configuration files we look for, in order of preference
+ */
+var configFiles = []string{"goref.yaml", "goref.yml", "goref.toml"}
+
+/**
+ * Generator settings. Every field is optional so that layers (defaults,
+ * the config file, package overrides and the command line) can be merged.
+ */
+type settings struct {
+  Ident         *string   `yaml:"ident,omitempty"           toml:"ident"`
+  BuildTag      *string   `yaml:"build-tag,omitempty"       toml:"build-tag"`
+  FileSuffix    *string   `yaml:"file-suffix,omitempty"     toml:"file-suffix"`
+  StripComments *bool     `yaml:"strip-comments,omitempty"  toml:"strip-comments"`
+  Force         *bool     `yaml:"force,omitempty"           toml:"force"`
+  Debug         *bool     `yaml:"debug,omitempty"           toml:"debug"`
+  Trace         *bool     `yaml:"trace,omitempty"           toml:"trace"`
+  Verbose       *bool     `yaml:"verbose,omitempty"         toml:"verbose"`
+  Constraint    *string   `yaml:"constraint,omitempty"      toml:"constraint"`
+  FixConstraints *bool    `yaml:"fix-constraints,omitempty" toml:"fix-constraints"`
+  Overlay       *string   `yaml:"overlay,omitempty"         toml:"overlay"`
+  OverlayPkg    *bool     `yaml:"overlay-pkg,omitempty"     toml:"overlay-pkg"`
+  TypeCheck     *bool     `yaml:"typecheck,omitempty"       toml:"typecheck"`
+  SharedRefs    *bool     `yaml:"shared-refs,omitempty"     toml:"shared-refs"`
+  SharedPkg     *string   `yaml:"shared-pkg,omitempty"      toml:"shared-pkg"`
+  Lazy          *bool     `yaml:"lazy,omitempty"            toml:"lazy"`
+  Cache         *bool     `yaml:"cache,omitempty"           toml:"cache"`
+  Registry      *bool     `yaml:"registry,omitempty"        toml:"registry"`
+  SQLTables     map[string]string `yaml:"sql-tables,omitempty" toml:"sql-tables"`
+  HTTPURLs      map[string]string `yaml:"http-urls,omitempty"  toml:"http-urls"`
+  Imports       []string  `yaml:"imports,omitempty"         toml:"imports"`
+}
+
+/**
+ * Merge another layer over this one. Set values in the other layer take
+ * precedence; imports accumulate.
+ */
+func (s settings) Merge(o settings) settings {
+  if o.Ident != nil {
+    s.Ident = o.Ident
+  }
+  if o.BuildTag != nil {
+    s.BuildTag = o.BuildTag
+  }
+  if o.FileSuffix != nil {
+    s.FileSuffix = o.FileSuffix
+  }
+  if o.StripComments != nil {
+    s.StripComments = o.StripComments
+  }
+  if o.Force != nil {
+    s.Force = o.Force
+  }
+  if o.Debug != nil {
+    s.Debug = o.Debug
+  }
+  if o.Trace != nil {
+    s.Trace = o.Trace
+  }
+  if o.Verbose != nil {
+    s.Verbose = o.Verbose
+  }
+  if o.Constraint != nil {
+    s.Constraint = o.Constraint
+  }
+  if o.FixConstraints != nil {
+    s.FixConstraints = o.FixConstraints
+  }
+  if o.Overlay != nil {
+    s.Overlay = o.Overlay
+  }
+  if o.OverlayPkg != nil {
+    s.OverlayPkg = o.OverlayPkg
+  }
+  if o.TypeCheck != nil {
+    s.TypeCheck = o.TypeCheck
+  }
+  if o.SharedRefs != nil {
+    s.SharedRefs = o.SharedRefs
+  }
+  if o.SharedPkg != nil {
+    s.SharedPkg = o.SharedPkg
+  }
+  if o.Lazy != nil {
+    s.Lazy = o.Lazy
+  }
+  if o.Cache != nil {
+    s.Cache = o.Cache
+  }
+  if o.Registry != nil {
+    s.Registry = o.Registry
+  }
+  if len(o.Imports) > 0 {
+    s.Imports = appendUnique(append([]string(nil), s.Imports...), o.Imports...)
+  }
+  if len(o.SQLTables) > 0 {
+    s.SQLTables = mergeMappings(s.SQLTables, o.SQLTables)
+  }
+  if len(o.HTTPURLs) > 0 {
+    s.HTTPURLs = mergeMappings(s.HTTPURLs, o.HTTPURLs)
+  }
+  return s
+}
+
+/**
+ * Merge type mappings; those in the second take precedence
+ */
+func mergeMappings(a, b map[string]string) map[string]string {
+  m := make(map[string]string)
+  for k, v := range a {
+    m[k] = v
+  }
+  for k, v := range b {
+    m[k] = v
+  }
+  return m
+}
+
+/**
+ * Parse type mappings from the command line: <type>=<value>
+ */
+func parseMappings(list flagList, what string) (map[string]string, error) {
+  var m map[string]string
+  for _, e := range list {
+    x := strings.Index(e, "=")
+    if x < 1 || x == len(e) - 1 {
+      return nil, fmt.Errorf("Invalid %s mapping: %q (expected <type>=<%s>)", what, e, strings.ToLower(what))
+    }
+    if m == nil {
+      m = make(map[string]string)
+    }
+    m[e[:x]] = e[x+1:]
+  }
+  return m, nil
+}
+
+/**
+ * Resolve paths in settings relative to the provided directory; paths in a
+ * config file are relative to the file.
+ */
+func (s settings) Relative(dir string) settings {
+  if s.Overlay != nil && *s.Overlay != "" && !filepath.IsAbs(*s.Overlay) {
+    s.Overlay = stringPtr(filepath.Join(dir, *s.Overlay))
+  }
+  return s
+}
+
+/**
+ * Per-package overrides
+ */
+type packageSettings struct {
+  Match     string    `yaml:"match" toml:"match"`
+  settings            `yaml:",inline"`
+}
+
+/**
+ * A configuration file
+ */
+type configFile struct {
+  Path      string              `yaml:"-" toml:"-"`
+  settings                      `yaml:",inline"`
+  Packages  []packageSettings   `yaml:"packages,omitempty" toml:"packages"`
+}
+
+/**
+ * Effective configuration for a package
+ */
+type config struct {
+  Package   string
+  Source    string
+  Matched   []string
+  settings
+}
+
+/**
+ * Default settings; these match the flag defaults
+ */
+func defaultSettings() settings {
+  return settings{
+    Ident:          stringPtr("string"),
+    BuildTag:       stringPtr(""),
+    FileSuffix:     stringPtr("_ref"),
+    StripComments:  boolPtr(true),
+    Force:          boolPtr(false),
+    Debug:          boolPtr(false),
+    Trace:          boolPtr(false),
+    Verbose:        boolPtr(false),
+    Constraint:     stringPtr(""),
+    FixConstraints: boolPtr(false),
+    Overlay:        stringPtr(""),
+    OverlayPkg:     boolPtr(false),
+    TypeCheck:      boolPtr(false),
+    SharedRefs:     boolPtr(false),
+    SharedPkg:      stringPtr(""),
+    Lazy:           boolPtr(false),
+    Cache:          boolPtr(false),
+    Registry:       boolPtr(false),
+  }
+}
+
+/**
+ * Produce a settings layer from flags that were explicitly set on the
+ * command line. Flags left at their defaults don't override anything.
+ */
+func flagSettings(cmdline *flag.FlagSet, imports, tables, urls flagList) (settings, error) {
+  var s settings
+  var err error
+  cmdline.Visit(func(f *flag.Flag) {
+    if err != nil {
+      return
+    }
+    v := f.Value.String()
+    switch f.Name {
+      case "ident":
+        s.Ident = stringPtr(v)
+      case "build-tag":
+        s.BuildTag = stringPtr(v)
+      case "file-suffix":
+        s.FileSuffix = stringPtr(v)
+      case "strip-comments":
+        s.StripComments, err = parseBoolPtr(f.Name, v)
+      case "force":
+        s.Force, err = parseBoolPtr(f.Name, v)
+      case "debug":
+        s.Debug, err = parseBoolPtr(f.Name, v)
+      case "trace":
+        s.Trace, err = parseBoolPtr(f.Name, v)
+      case "verbose":
+        s.Verbose, err = parseBoolPtr(f.Name, v)
+      case "constraint":
+        s.Constraint = stringPtr(v)
+      case "fix-constraints":
+        s.FixConstraints, err = parseBoolPtr(f.Name, v)
+      case "overlay":
+        s.Overlay = stringPtr(v)
+      case "overlay-pkg":
+        s.OverlayPkg, err = parseBoolPtr(f.Name, v)
+      case "typecheck":
+        s.TypeCheck, err = parseBoolPtr(f.Name, v)
+      case "shared-refs":
+        s.SharedRefs, err = parseBoolPtr(f.Name, v)
+      case "shared-pkg":
+        s.SharedPkg = stringPtr(v)
+      case "lazy":
+        s.Lazy, err = parseBoolPtr(f.Name, v)
+      case "cache":
+        s.Cache, err = parseBoolPtr(f.Name, v)
+      case "registry":
+        s.Registry, err = parseBoolPtr(f.Name, v)
+    }
+  })
+  if err != nil {
+    return settings{}, err
+  }
+  if len(imports) > 0 {
+    s.Imports = append([]string(nil), imports...)
+  }
+  s.SQLTables, err = parseMappings(tables, "table")
+  if err != nil {
+    return settings{}, err
+  }
+  s.HTTPURLs, err = parseMappings(urls, "URL")
+  if err != nil {
+    return settings{}, err
+  }
+  return s, nil
+}
+
+/**
+ * Find the nearest configuration file, walking up from the provided
+ * directory. If no file is found an empty path is returned.
+ */
+func findConfig(dir string) (string, error) {
+  abs, err := filepath.Abs(dir)
+  if err != nil {
+    return "", err
+  }
+  for {
+    for _, e := range configFiles {
+      p := filepath.Join(abs, e)
+      info, err := os.Stat(p)
+      if err == nil && !info.IsDir() {
+        return p, nil
+      }else if err != nil && !os.IsNotExist(err) {
+        return "", err
+      }
+    }
+    parent := filepath.Dir(abs)
+    if parent == abs {
+      return "", nil
+    }
+    abs = parent
+  }
+}
+
+/**
+ * Load a configuration file
+ */
+func loadConfigFile(p string) (*configFile, error) {
+  data, err := os.ReadFile(p)
+  if err != nil {
+    return nil, err
+  }
+  conf := &configFile{Path:p}
+  switch filepath.Ext(p) {
+    case ".toml":
+      err = toml.Unmarshal(data, conf)
+    default:
+      err = yaml.Unmarshal(data, conf)
+  }
+  if err != nil {
+    return nil, fmt.Errorf("%v: %v", p, err)
+  }
+  for _, e := range conf.Packages {
+    if e.Match == "" {
+      return nil, fmt.Errorf("%v: package override has no match pattern", p)
+    }
+  }
+  return conf, nil
+}
+
+/**
+ * Compute the effective configuration for the package in the provided
+ * directory: defaults, then the nearest config file, then any matching
+ * package overrides (in order), then the command line.
+ */
+func loadConfig(dir string, cli settings) (*config, error) {
+  abs, err := filepath.Abs(dir)
+  if err != nil {
+    return nil, err
+  }
+  
+  cnf := &config{Package:abs, settings:defaultSettings()}
+  
+  p, err := findConfig(abs)
+  if err != nil {
+    return nil, err
+  }
+  if p != "" {
+    file, err := loadConfigFile(p)
+    if err != nil {
+      return nil, err
+    }
+    cnf.Source = p
+    cnf.settings = cnf.settings.Merge(file.settings.Relative(filepath.Dir(p)))
+    
+    rel, err := filepath.Rel(filepath.Dir(p), abs)
+    if err != nil {
+      return nil, err
+    }
+    for _, e := range file.Packages {
+      ok, err := matchPackage(e.Match, filepath.ToSlash(rel))
+      if err != nil {
+        return nil, fmt.Errorf("%v: %v", p, err)
+      }
+      if ok {
+        cnf.Matched = append(cnf.Matched, e.Match)
+        cnf.settings = cnf.settings.Merge(e.settings.Relative(filepath.Dir(p)))
+      }
+    }
+  }
+  
+  cnf.settings = cnf.settings.Merge(cli)
+  return cnf, nil
+}
+
+/**
+ * Apply the configuration to the generator
+ */
+func (c *config) Apply() {
+  DEBUG           = *c.Debug
+  TRACE           = *c.Trace
+  VERBOSE         = *c.Verbose
+  FORCE           = *c.Force
+  idType          = *c.Ident
+  buildTag        = *c.BuildTag
+  fileSuffix      = *c.FileSuffix
+  stripComments   = *c.StripComments
+  constraintTag   = *c.Constraint
+  fixConstraints  = *c.FixConstraints
+  overlayDir      = *c.Overlay
+  overlayPkg      = *c.OverlayPkg
+  typeCheck       = *c.TypeCheck
+  sharedRefs      = *c.SharedRefs
+  sharedPkg       = *c.SharedPkg
+  cacheRefs       = *c.Cache
+  lazyRefs        = *c.Lazy || cacheRefs // caches wrap loaders
+  refRegistry     = *c.Registry
+  sqlTables       = c.SQLTables
+  httpURLs        = c.HTTPURLs
+  
+  if overlayDir != "" {
+    if abs, err := filepath.Abs(overlayDir); err == nil {
+      overlayDir = abs
+    }
+  }
+  
+  extraImports = nil
+  if len(c.Imports) > 0 {
+    extraImports = make(importSet)
+    for _, e := range c.Imports {
+      extraImports.Add(&ast.ImportSpec{Path:&ast.BasicLit{Kind:token.STRING, Value:strconv.Quote(e)}})
+    }
+  }
+}
+
+/**
+ * Write the effective configuration
+ */
+func (c *config) Write(w io.Writer) error {
+  fmt.Fprintf(w, "# package: %v\n", c.Package)
+  if c.Source != "" {
+    fmt.Fprintf(w, "# config: %v\n", c.Source)
+  }else{
+    fmt.Fprintf(w, "# config: (none)\n")
+  }
+  for _, e := range c.Matched {
+    fmt.Fprintf(w, "# matched: %v\n", e)
+  }
+  data, err := yaml.Marshal(c.settings)
+  if err != nil {
+    return err
+  }
+  _, err = w.Write(data)
+  return err
+}
+
+/**
+ * Match a package path, relative to the config file, against a pattern.
+ * Patterns are globs; a trailing "/..." matches the directory and
+ * everything beneath it, as with the go tool.
+ */
+func matchPackage(pattern, rel string) (bool, error) {
+  if pattern == "..." || pattern == "./..." {
+    return true, nil
+  }
+  pattern = strings.TrimPrefix(pattern, "./")
+  if strings.HasSuffix(pattern, "/...") {
+    base := strings.TrimSuffix(pattern, "/...")
+    if ok, err := filepath.Match(base, rel); ok || err != nil {
+      return ok, err
+    }
+    for p := rel; p != "." && p != "/" && p != ""; p = filepath.ToSlash(filepath.Dir(p)) {
+      if ok, err := filepath.Match(base, p); ok || err != nil {
+        return ok, err
+      }
+    }
+    return false, nil
+  }
+  if pattern == "." {
+    return rel == ".", nil
+  }
+  return filepath.Match(pattern, rel)
+}
+
+func parseBoolPtr(n, v string) (*bool, error) {
+  b, err := strconv.ParseBool(v)
+  if err != nil {
+    return nil, fmt.Errorf("Invalid value for -%s: %v", n, v)
+  }
+  return &b, nil
+}
+
+func stringPtr(v string) *string {
+  return &v
+}
+
+func boolPtr(v bool) *bool {
+  return &v
+}
+
+func appendUnique(s []string, v ...string) []string {
+  outer:
+  for _, e := range v {
+    for _, x := range s {
+      if x == e {
+        continue outer
+      }
+    }
+    s = append(s, e)
+  }
+  return s
+}
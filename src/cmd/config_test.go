@@ -0,0 +1,95 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestMatchPackage(t *testing.T) {
+  tests := []struct{
+    Pattern, Path string
+    Expect        bool
+  }{
+    {".", ".", true},
+    {"...", "a/b", true},
+    {"a", "a", true},
+    {"a", "a/b", false},
+    {"a/*", "a/b", true},
+    {"a/...", "a", true},
+    {"a/...", "a/b/c", true},
+    {"./a/...", "a/b", true},
+    {"a/...", "ab/c", false},
+    {"*/internal/...", "x/internal/y", true},
+  }
+  for _, e := range tests {
+    ok, err := matchPackage(e.Pattern, e.Path)
+    if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+      assert.Equal(t, e.Expect, ok, fmt.Sprintf("%v ~ %v", e.Pattern, e.Path))
+    }
+  }
+}
+
+func TestLoadConfig(t *testing.T) {
+  root := t.TempDir()
+  pkg := filepath.Join(root, "a", "b")
+  err := os.MkdirAll(pkg, 0755)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  err = os.WriteFile(filepath.Join(root, "goref.yaml"), []byte(`
+ident: int64
+file-suffix: _gen
+imports: [time]
+sql-tables: {User: users, Post: posts}
+packages:
+  - match: a/...
+    ident: uint64
+    imports: [net/url]
+    sql-tables: {Post: blog.posts}
+    http-urls: {User: "/users/{id}"}
+  - match: c
+    ident: string
+`), 0644)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  cnf, err := loadConfig(pkg, settings{FileSuffix:stringPtr("_cli")})
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, filepath.Join(root, "goref.yaml"), cnf.Source)
+    assert.Equal(t, []string{"a/..."}, cnf.Matched)
+    assert.Equal(t, "uint64", *cnf.Ident)
+    assert.Equal(t, "_cli", *cnf.FileSuffix)
+    assert.Equal(t, true, *cnf.StripComments)
+    assert.Equal(t, []string{"time", "net/url"}, cnf.Imports)
+    assert.Equal(t, map[string]string{"User":"users", "Post":"blog.posts"}, cnf.SQLTables)
+    assert.Equal(t, map[string]string{"User":"/users/{id}"}, cnf.HTTPURLs)
+  }
+  
+  err = os.Remove(filepath.Join(root, "goref.yaml"))
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  err = os.WriteFile(filepath.Join(root, "a", "goref.toml"), []byte(`
+ident = "int"
+
+[[packages]]
+match = "b"
+build-tag = "+build !ignore"
+`), 0644)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  cnf, err = loadConfig(pkg, settings{})
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, filepath.Join(root, "a", "goref.toml"), cnf.Source)
+    assert.Equal(t, "int", *cnf.Ident)
+    assert.Equal(t, "+build !ignore", *cnf.BuildTag)
+    assert.Equal(t, "_ref", *cnf.FileSuffix)
+  }
+}
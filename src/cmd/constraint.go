@@ -0,0 +1,269 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "strings"
+  "go/ast"
+  "go/build/constraint"
+)
+
+/**
+ * The conventional tag used to exclude a file from every build. Originals
+ * were traditionally marked with it, so we treat it as never being set.
+ */
+const ignoreTag = "ignore"
+
+/**
+ * Beyond this many tags we don't try to prove two constraints are
+ * mutually exclusive.
+ */
+const maxConstraintTags = 16
+
+/**
+ * Parse the build constraint of a file, if it has one. Constraints are the
+ * //go:build line or, failing that, any // +build lines (which are ANDed)
+ * appearing before the package clause. The comments that make up the
+ * constraint are returned along with it.
+ */
+func fileConstraint(file *ast.File) (constraint.Expr, []*ast.Comment, error) {
+  var gobuild constraint.Expr
+  var plusbuild constraint.Expr
+  var lines []*ast.Comment
+  
+  for _, g := range file.Comments {
+    if g.Pos() >= file.Package {
+      break
+    }
+    for _, e := range g.List {
+      if constraint.IsGoBuild(e.Text) {
+        x, err := constraint.Parse(e.Text)
+        if err != nil {
+          return nil, nil, err
+        }
+        gobuild = x
+        lines = append(lines, e)
+      }else if constraint.IsPlusBuild(e.Text) {
+        x, err := constraint.Parse(e.Text)
+        if err != nil {
+          return nil, nil, err
+        }
+        plusbuild = andConstraint(plusbuild, x)
+        lines = append(lines, e)
+      }
+    }
+  }
+  
+  if gobuild != nil {
+    return gobuild, lines, nil
+  }else{
+    return plusbuild, lines, nil
+  }
+}
+
+/**
+ * Remove build constraint comments from a file, which we do before printing
+ * a copy of it since the copy gets its own combined constraint.
+ */
+func stripConstraints(file *ast.File, lines []*ast.Comment) {
+  if len(lines) < 1 {
+    return
+  }
+  
+  drop := make(map[*ast.Comment]struct{})
+  for _, e := range lines {
+    drop[e] = struct{}{}
+  }
+  
+  var groups []*ast.CommentGroup
+  for _, g := range file.Comments {
+    var keep []*ast.Comment
+    for _, e := range g.List {
+      if _, ok := drop[e]; !ok {
+        keep = append(keep, e)
+      }
+    }
+    if len(keep) > 0 {
+      g.List = keep
+      groups = append(groups, g)
+    }
+  }
+  file.Comments = groups
+}
+
+/**
+ * Compute the constraint for a generated copy of a source with the provided
+ * constraint: the generated tag, ANDed with whatever the original requires
+ * apart from the terms that exclude it in favor of the copy.
+ */
+func copyConstraint(orig constraint.Expr, tag string) constraint.Expr {
+  return andConstraint(&constraint.TagExpr{Tag:tag}, withoutTags(orig, tag, ignoreTag))
+}
+
+/**
+ * Compute the constraint an original source needs so that it is excluded
+ * when its generated copy is built.
+ */
+func origConstraint(orig constraint.Expr, tag string) constraint.Expr {
+  return andConstraint(withoutTags(orig, tag), &constraint.NotExpr{X:&constraint.TagExpr{Tag:tag}})
+}
+
+/**
+ * Remove the provided tags (negated or not) where they are terms of an AND
+ * expression. If nothing is left nil is returned.
+ */
+func withoutTags(x constraint.Expr, tags ...string) constraint.Expr {
+  switch v := x.(type) {
+    case nil:
+      return nil
+    case *constraint.TagExpr:
+      for _, e := range tags {
+        if v.Tag == e {
+          return nil
+        }
+      }
+      return v
+    case *constraint.NotExpr:
+      if t, ok := v.X.(*constraint.TagExpr); ok {
+        if withoutTags(t, tags...) == nil {
+          return nil
+        }
+      }
+      return v
+    case *constraint.AndExpr:
+      return andConstraint(withoutTags(v.X, tags...), withoutTags(v.Y, tags...))
+    default:
+      return x
+  }
+}
+
+func andConstraint(x, y constraint.Expr) constraint.Expr {
+  if x == nil {
+    return y
+  }else if y == nil {
+    return x
+  }else{
+    return &constraint.AndExpr{X:x, Y:y}
+  }
+}
+
+/**
+ * Find an assignment of tags under which both constraints are satisfied, if
+ * there is one, which would mean both files compile together. Tags are few
+ * in practice so we simply try every combination.
+ */
+func overlapConstraints(x, y constraint.Expr) ([]string, bool) {
+  both := andConstraint(x, y)
+  if both == nil {
+    return nil, true
+  }
+  
+  seen := make(map[string]struct{})
+  both.Eval(func(tag string) bool {
+    if tag != ignoreTag {
+      seen[tag] = struct{}{}
+    }
+    return false
+  })
+  var tags []string
+  for k := range seen {
+    tags = append(tags, k)
+  }
+  sort.Strings(tags)
+  if len(tags) > maxConstraintTags {
+    return nil, false
+  }
+  
+  for m := 0; m < 1 << uint(len(tags)); m++ {
+    set := make(map[string]bool)
+    for i, e := range tags {
+      set[e] = m & (1 << uint(i)) != 0
+    }
+    if both.Eval(func(tag string) bool { return set[tag] }) {
+      var on []string
+      for _, e := range tags {
+        if set[e] {
+          on = append(on, e)
+        }
+      }
+      return on, true
+    }
+  }
+  
+  return nil, false
+}
+
+/**
+ * Check that an original and its generated copy can never be compiled
+ * together, and either fix the original or tell the user how to. A fixed
+ * original is written with the package's other output.
+ */
+func checkConstraints(cxt *context, src string, orig, gen constraint.Expr, tag string) error {
+  on, overlap := overlapConstraints(orig, gen)
+  if !overlap {
+    return nil
+  }
+  
+  want := origConstraint(orig, tag)
+  if fixConstraints {
+    o, err := rewriteConstraint(src, want)
+    if err != nil {
+      return err
+    }
+    err = cxt.Output.Append(o)
+    if err != nil {
+      return err
+    }
+    if VERBOSE {
+      fmt.Printf("%v: %v: updated build constraint: %v\n", CMD, src, want)
+    }
+    return nil
+  }
+  
+  fmt.Printf("%v: %v: warning: source and its generated copy both compile with tags [%v]; add %q to the source (or use -fix-constraints)\n", CMD, src, strings.Join(on, ","), constraintLine(want))
+  return nil
+}
+
+/**
+ * Rewrite the build constraint of a source file, to be written in place. Any
+ * existing constraint lines are replaced by a single //go:build line; if
+ * there were none, one is inserted at the top of the file.
+ */
+func rewriteConstraint(src string, x constraint.Expr) (*output, error) {
+  data, err := os.ReadFile(src)
+  if err != nil {
+    return nil, err
+  }
+  info, err := os.Stat(src)
+  if err != nil {
+    return nil, err
+  }
+  
+  lines := strings.SplitAfter(string(data), "\n")
+  var out []string
+  var done, header bool
+  for _, l := range lines {
+    t := strings.TrimSpace(l)
+    if !header && strings.HasPrefix(t, "package ") {
+      header = true
+    }
+    if !header && (constraint.IsGoBuild(t) || constraint.IsPlusBuild(t)) {
+      if !done {
+        out = append(out, constraintLine(x) +"\n")
+        done = true
+      }
+      continue
+    }
+    out = append(out, l)
+  }
+  if !done {
+    out = append([]string{constraintLine(x) +"\n", "\n"}, out...)
+  }
+  
+  return &output{Path:src, Name:src, Data:[]byte(strings.Join(out, "")), Mode:info.Mode().Perm()}, nil
+}
+
+func constraintLine(x constraint.Expr) string {
+  return "//go:build "+ x.String()
+}
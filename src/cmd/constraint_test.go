@@ -0,0 +1,73 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "testing"
+  "path/filepath"
+  "go/build/constraint"
+  "github.com/stretchr/testify/assert"
+)
+
+func parseConstraint(t *testing.T, s string) constraint.Expr {
+  if s == "" {
+    return nil
+  }
+  x, err := constraint.Parse("//go:build "+ s)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    t.FailNow()
+  }
+  return x
+}
+
+func TestConstraints(t *testing.T) {
+  tests := []struct{
+    Orig, Copy, Fixed string
+    Overlap           bool
+  }{
+    {"", "goref", "!goref", true},
+    {"ignore", "goref", "ignore && !goref", false},
+    {"!goref", "goref", "!goref", false},
+    {"linux", "goref && linux", "linux && !goref", true},
+    {"linux && !goref", "goref && linux", "linux && !goref", false},
+    {"(linux || darwin) && ignore", "goref && (linux || darwin)", "(linux || darwin) && ignore && !goref", false},
+  }
+  for _, e := range tests {
+    orig := parseConstraint(t, e.Orig)
+    gen := copyConstraint(orig, "goref")
+    assert.Equal(t, e.Copy, gen.String(), e.Orig)
+    assert.Equal(t, e.Fixed, origConstraint(orig, "goref").String(), e.Orig)
+    _, overlap := overlapConstraints(orig, gen)
+    assert.Equal(t, e.Overlap, overlap, e.Orig)
+    _, overlap = overlapConstraints(origConstraint(orig, "goref"), gen)
+    assert.Equal(t, false, overlap, e.Orig)
+  }
+}
+
+func TestFixConstraints(t *testing.T) {
+  src := "package a\n\ntype User struct{}\n\ntype Post struct {\n  A *User `json:\"a\" ref:\"a_id\"`\n}\n"
+  applySettings(t, settings{Constraint:stringPtr("goref"), FixConstraints:boolPtr(true)})
+  
+  // the source is fixed along with the rest of the output
+  dir := writePackage(t, map[string]string{"a.go": src})
+  err := procDir(dir, optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  data, err := os.ReadFile(filepath.Join(dir, "a.go"))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, "//go:build !goref\n\n"+ src, string(data))
+  }
+  _, err = os.Stat(filepath.Join(dir, "a"+ fileSuffix +".go"))
+  assert.Nil(t, err, fmt.Sprintf("%v", err))
+  
+  // and left alone when the package fails, here because a source's copy
+  // would be written over the package file
+  dir = writePackage(t, map[string]string{"a.go": src, pkgSrc +".go": "package a\n\ntype Page struct {\n  A *User `json:\"a\" ref:\"a_id\"`\n}\n"})
+  err = procDir(dir, optionNone)
+  assert.NotNil(t, err)
+  data, err = os.ReadFile(filepath.Join(dir, "a.go"))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, src, string(data))
+  }
+}
@@ -2,6 +2,7 @@ package main
 
 import (
   "os"
+  "strings"
 )
 
 /**
@@ -25,3 +26,27 @@ func isFileOutOfDate(dst, src string) (bool, error) {
   
   return sf.ModTime().After(df.ModTime()), nil
 }
+
+/**
+ * Determine if a file was generated by us. This only looks at the leading
+ * comments, so it works even if the file was left partially written.
+ */
+func isFileGenerated(p string) (bool, error) {
+  data, err := os.ReadFile(p)
+  if err != nil {
+    return false, err
+  }
+  for _, l := range strings.Split(string(data), "\n") {
+    l = strings.TrimSpace(l)
+    if l == "" {
+      continue
+    }
+    if !strings.HasPrefix(l, "//") {
+      break // comments are over; the package clause or something else
+    }
+    if strings.HasPrefix(strings.TrimSpace(l[2:]), generatedHeader) {
+      return true, nil
+    }
+  }
+  return false, nil
+}
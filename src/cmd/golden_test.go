@@ -0,0 +1,157 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "flag"
+  "sort"
+  "strings"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "Update golden files with the current output.")
+
+/**
+ * Fixtures with golden output, relative to the test data directory. Each is
+ * generated with the settings in its own config file, if it has one, as it
+ * is by test/bin/run.sh.
+ */
+var goldenFixtures = map[string]string{
+  "basic":    "data/basic",
+  "example":  "../example/pkg",
+  "lazy":     "data/lazy",
+  "cache":    "data/cache",
+  "registry": "data/registry",
+  "ident":    "data/ident",
+  "resolver": "data/resolver",
+  "http":     "data/http",
+}
+
+func testDataDir() string {
+  if d := os.Getenv("REF_TEST_DATA"); d != "" {
+    return d
+  }
+  return filepath.Join("..", "..", "test")
+}
+
+/**
+ * Write sources to a temporary package directory
+ */
+func writePackage(t *testing.T, files map[string]string) string {
+  dir := t.TempDir()
+  for k, v := range files {
+    if !assert.Nil(t, os.WriteFile(filepath.Join(dir, k), []byte(v), 0644)) {
+      t.FailNow()
+    }
+  }
+  return dir
+}
+
+/**
+ * Apply settings over the defaults for the duration of a test
+ */
+func applySettings(t *testing.T, s settings) {
+  (&config{settings:defaultSettings().Merge(s)}).Apply()
+  t.Cleanup(func() {
+    (&config{settings:defaultSettings()}).Apply()
+  })
+}
+
+/**
+ * Generate a fixture in a temporary directory and return the generated
+ * files by name
+ */
+func generateFixture(t *testing.T, src string, s settings) map[string]string {
+  dir := t.TempDir()
+  srcs, err := filepath.Glob(filepath.Join(src, "*.go"))
+  if !assert.Nil(t, err) || !assert.NotEmpty(t, srcs) {
+    t.FailNow()
+  }
+  for _, e := range srcs {
+    data, err := os.ReadFile(e)
+    if assert.Nil(t, err) {
+      assert.Nil(t, os.WriteFile(filepath.Join(dir, filepath.Base(e)), data, 0644))
+    }
+  }
+  
+  // generate from inside the directory so paths in the output are relative
+  wd, err := os.Getwd()
+  if !assert.Nil(t, err) {
+    t.FailNow()
+  }
+  defer os.Chdir(wd)
+  assert.Nil(t, os.Chdir(dir))
+  
+  (&config{settings:defaultSettings().Merge(s)}).Apply()
+  FORCE = true
+  defer (&config{settings:defaultSettings()}).Apply()
+  if !assert.Nil(t, procDir(".", optionNone)) {
+    t.FailNow()
+  }
+  
+  gen := make(map[string]string)
+  outs, err := filepath.Glob("*"+ fileSuffix +".go")
+  if assert.Nil(t, err) {
+    for _, e := range outs {
+      data, err := os.ReadFile(e)
+      if assert.Nil(t, err) {
+        gen[e] = string(data)
+      }
+    }
+  }
+  return gen
+}
+
+func TestGolden(t *testing.T) {
+  names := make([]string, 0, len(goldenFixtures))
+  for k := range goldenFixtures {
+    names = append(names, k)
+  }
+  sort.Strings(names)
+  
+  for _, name := range names {
+    t.Run(name, func(t *testing.T) {
+      src, err := filepath.Abs(filepath.Join(testDataDir(), goldenFixtures[name]))
+      if !assert.Nil(t, err) {
+        return
+      }
+      cnf, err := loadConfig(src, settings{})
+      if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+        return
+      }
+      gen := generateFixture(t, src, cnf.settings)
+      assert.Equal(t, gen, generateFixture(t, src, cnf.settings), "Output differs between runs")
+      
+      dir := filepath.Join("testdata", "golden", name)
+      if *updateGolden {
+        assert.Nil(t, os.RemoveAll(dir))
+        assert.Nil(t, os.MkdirAll(dir, 0755))
+        for k, v := range gen {
+          assert.Nil(t, os.WriteFile(filepath.Join(dir, k +".golden"), []byte(v), 0644))
+        }
+        return
+      }
+      
+      goldens, err := filepath.Glob(filepath.Join(dir, "*.golden"))
+      if !assert.Nil(t, err) {
+        return
+      }
+      expect := make(map[string]string)
+      for _, e := range goldens {
+        data, err := os.ReadFile(e)
+        if assert.Nil(t, err) {
+          expect[strings.TrimSuffix(filepath.Base(e), ".golden")] = string(data)
+        }
+      }
+      for k, v := range expect {
+        assert.Equal(t, v, gen[k], "Generated %v does not match its golden file (run the tests with -update to regenerate)", k)
+      }
+      for k := range gen {
+        _, ok := expect[k]
+        assert.True(t, ok, "Generated %v has no golden file", k)
+      }
+    })
+  }
+}
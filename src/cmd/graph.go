@@ -0,0 +1,336 @@
+package main
+
+import (
+  "io"
+  "fmt"
+  "sort"
+  "strings"
+  "strconv"
+  "encoding/json"
+)
+
+/**
+ * Graph output formats
+ */
+const (
+  graphDOT      = "dot"
+  graphMermaid  = "mermaid"
+  graphJSON     = "json"
+)
+
+/**
+ * A type in the reference graph: a struct with ref fields or a type one of
+ * them refers to
+ */
+type graphNode struct {
+  Id        string    `json:"id"`
+  Package   string    `json:"package"`
+  Name      string    `json:"name"`
+  External  bool      `json:"external"`
+  Cycle     bool      `json:"cycle"`
+}
+
+/**
+ * A ref field, as an edge from the struct that declares it to the type it
+ * refers to
+ */
+type graphEdge struct {
+  From      string    `json:"from"`
+  To        string    `json:"to"`
+  Field     string    `json:"field"`
+  Marshal   string    `json:"marshal"`
+  CrossPackage bool   `json:"cross_package"`
+  Cycle     bool      `json:"cycle"`
+}
+
+/**
+ * The graph of how types refer to each other through ref fields. Cycles are
+ * listed as the types that take part in each.
+ */
+type refGraph struct {
+  Nodes     []graphNode `json:"nodes"`
+  Edges     []graphEdge `json:"edges"`
+  Cycles    [][]string  `json:"cycles"`
+}
+
+/**
+ * The type a referenced type is made of, without the pointers, slices and
+ * maps that hold it
+ */
+func graphElem(t string) string {
+  for {
+    switch {
+      case strings.HasPrefix(t, "*"):
+        t = t[1:]
+      case strings.HasPrefix(t, "[]"):
+        t = t[2:]
+      case strings.HasPrefix(t, "map["):
+        d, x := 0, -1
+        for i := 0; i < len(t) && x < 0; i++ {
+          if t[i] == '[' {
+            d++
+          }else if t[i] == ']' {
+            if d--; d == 0 {
+              x = i
+            }
+          }
+        }
+        if x < 0 {
+          return t
+        }
+        t = t[x+1:]
+      default:
+        return t
+    }
+  }
+}
+
+/**
+ * The package qualifier and name of the type a referenced type is made of.
+ * The qualifier is empty for a type in the package that refers to it.
+ */
+func graphType(t string) (string, string) {
+  t = graphElem(t)
+  if x := strings.LastIndex(strings.Split(t, "[")[0], "."); x > 0 {
+    return t[:x], t[x+1:]
+  }
+  return "", t
+}
+
+/**
+ * Build the reference graph from a listing. Types are identified by the
+ * import paths of their packages, or by package names where paths are not
+ * known, and are external unless their packages are in the listing. Edges to
+ * types declared in other packages are left out unless cross is set.
+ */
+func buildGraph(fields []listField, cross bool) *refGraph {
+  pathOf := func(path, pkg string) string {
+    if path != "" {
+      return path
+    }
+    return pkg
+  }
+  loaded := make(map[string]string)
+  for _, e := range fields {
+    loaded[pathOf(e.Path, e.Package)] = e.Package
+  }
+  
+  g := &refGraph{Nodes:[]graphNode{}, Edges:[]graphEdge{}, Cycles:[][]string{}}
+  index := make(map[string]int)
+  node := func(path, pkg, name string) string {
+    id := path +"."+ name
+    if _, ok := index[id]; !ok {
+      n, ok := loaded[path]
+      if ok {
+        pkg = n
+      }
+      index[id] = len(g.Nodes)
+      g.Nodes = append(g.Nodes, graphNode{Id:id, Package:pkg, Name:name, External:!ok})
+    }
+    return id
+  }
+  
+  for _, e := range fields {
+    path := pathOf(e.Path, e.Package)
+    from := node(path, e.Package, e.Struct)
+    qual, t := graphType(e.Type)
+    to, pkg := path, e.Package
+    if qual != "" {
+      to, pkg = pathOf(e.TypePath, qual), qual
+    }
+    if to != path && !cross {
+      continue
+    }
+    g.Edges = append(g.Edges, graphEdge{
+      From:         from,
+      To:           node(to, pkg, t),
+      Field:        e.Field,
+      Marshal:      e.Marshal,
+      CrossPackage: to != path,
+    })
+  }
+  
+  g.findCycles(index)
+  return g
+}
+
+/**
+ * Find the cycles in the graph, which are its strongly connected components
+ * with more than one type, or one type that refers to itself, and mark the
+ * types and edges that take part in them
+ */
+func (g *refGraph) findCycles(index map[string]int) {
+  adj := make([][]int, len(g.Nodes))
+  for _, e := range g.Edges {
+    adj[index[e.From]] = append(adj[index[e.From]], index[e.To])
+  }
+  
+  // Tarjan's algorithm
+  var stack []int
+  num, low := make([]int, len(g.Nodes)), make([]int, len(g.Nodes))
+  onStack := make([]bool, len(g.Nodes))
+  comp := make([]int, len(g.Nodes))
+  var n, c int
+  var visit func(int)
+  visit = func(v int) {
+    n++
+    num[v], low[v] = n, n
+    stack = append(stack, v)
+    onStack[v] = true
+    for _, w := range adj[v] {
+      if num[w] == 0 {
+        visit(w)
+        if low[w] < low[v] {
+          low[v] = low[w]
+        }
+      }else if onStack[w] && num[w] < low[v] {
+        low[v] = num[w]
+      }
+    }
+    if low[v] == num[v] {
+      var members []int
+      for {
+        w := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        onStack[w] = false
+        comp[w] = c
+        members = append(members, w)
+        if w == v {
+          break
+        }
+      }
+      c++
+      
+      cycle := len(members) > 1
+      for _, w := range adj[v] {
+        cycle = cycle || w == v
+      }
+      if cycle {
+        var ids []string
+        for _, w := range members {
+          g.Nodes[w].Cycle = true
+          ids = append(ids, g.Nodes[w].Id)
+        }
+        sort.Strings(ids)
+        g.Cycles = append(g.Cycles, ids)
+      }
+    }
+  }
+  for v := range g.Nodes {
+    if num[v] == 0 {
+      visit(v)
+    }
+  }
+  
+  for i, e := range g.Edges {
+    a, b := index[e.From], index[e.To]
+    g.Edges[i].Cycle = comp[a] == comp[b] && g.Nodes[a].Cycle
+  }
+  sort.Slice(g.Cycles, func(i, j int) bool {
+    return g.Cycles[i][0] < g.Cycles[j][0]
+  })
+}
+
+/**
+ * The label of an edge: the field and how it's marshaled
+ */
+func (e graphEdge) Label() string {
+  return e.Field +" ("+ e.Marshal +")"
+}
+
+/**
+ * Write a graph in Graphviz DOT. Types and edges in cycles are red; edges to
+ * other packages are dashed.
+ */
+func writeGraphDOT(w io.Writer, g *refGraph) error {
+  var b strings.Builder
+  b.WriteString("digraph refs {\n  rankdir=LR;\n  node [shape=box];\n")
+  for _, e := range g.Nodes {
+    var attrs []string
+    if e.External {
+      attrs = append(attrs, "style=dashed")
+    }
+    if e.Cycle {
+      attrs = append(attrs, "color=red")
+    }
+    fmt.Fprintf(&b, "  %s", strconv.Quote(e.Id))
+    if len(attrs) > 0 {
+      fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+    }
+    b.WriteString(";\n")
+  }
+  for _, e := range g.Edges {
+    attrs := []string{"label="+ strconv.Quote(e.Label())}
+    if e.CrossPackage {
+      attrs = append(attrs, "style=dashed")
+    }
+    if e.Cycle {
+      attrs = append(attrs, "color=red", "fontcolor=red")
+    }
+    fmt.Fprintf(&b, "  %s -> %s [%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), strings.Join(attrs, ", "))
+  }
+  b.WriteString("}\n")
+  _, err := io.WriteString(w, b.String())
+  return err
+}
+
+/**
+ * Write a graph as a Mermaid flowchart. Types and edges in cycles are red;
+ * edges to other packages are dotted.
+ */
+func writeGraphMermaid(w io.Writer, g *refGraph) error {
+  var b strings.Builder
+  b.WriteString("flowchart LR\n")
+  ids := make(map[string]string)
+  var cycle []string
+  for i, e := range g.Nodes {
+    ids[e.Id] = fmt.Sprintf("n%d", i)
+    fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids[e.Id], e.Id)
+    if e.Cycle {
+      cycle = append(cycle, ids[e.Id])
+    }
+  }
+  var links []string
+  for i, e := range g.Edges {
+    arrow := "-->"
+    if e.CrossPackage {
+      arrow = "-.->"
+    }
+    fmt.Fprintf(&b, "  %s %s|\"%s\"| %s\n", ids[e.From], arrow, e.Label(), ids[e.To])
+    if e.Cycle {
+      links = append(links, strconv.Itoa(i))
+    }
+  }
+  if len(cycle) > 0 {
+    b.WriteString("  classDef cycle stroke:red,color:red\n")
+    fmt.Fprintf(&b, "  class %s cycle\n", strings.Join(cycle, ","))
+    fmt.Fprintf(&b, "  linkStyle %s stroke:red,color:red\n", strings.Join(links, ","))
+  }
+  _, err := io.WriteString(w, b.String())
+  return err
+}
+
+/**
+ * Write a graph as JSON
+ */
+func writeGraphJSON(w io.Writer, g *refGraph) error {
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  return enc.Encode(g)
+}
+
+/**
+ * Write a graph in a format
+ */
+func writeGraph(w io.Writer, g *refGraph, format string) error {
+  switch format {
+    case graphDOT:
+      return writeGraphDOT(w, g)
+    case graphMermaid:
+      return writeGraphMermaid(w, g)
+    case graphJSON:
+      return writeGraphJSON(w, g)
+    default:
+      return fmt.Errorf("Unsupported graph format: %q (expected one of: %s, %s, %s)", format, graphDOT, graphMermaid, graphJSON)
+  }
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+  "bytes"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestGraphElem(t *testing.T) {
+  tests := map[string]string{
+    "User":                 "User",
+    "*User":                "User",
+    "[]*User":              "User",
+    "map[string][]User":    "User",
+    "map[[2]int]*x.User":   "x.User",
+    "*Page[User]":          "Page[User]",
+  }
+  for k, v := range tests {
+    assert.Equal(t, v, graphElem(k), k)
+  }
+}
+
+func TestBuildGraph(t *testing.T) {
+  fields := []listField{
+    {Package:"a", Struct:"Post", Field:"Author", Type:"*User", Marshal:"id"},
+    {Package:"a", Struct:"User", Field:"Posts", Type:"[]*Post", Marshal:"value"},
+    {Package:"a", Struct:"User", Field:"Manager", Type:"*User", Marshal:"id"},
+    {Package:"a", Struct:"Post", Field:"Tags", Type:"[]Tag", Marshal:"id"},
+    {Package:"a", Struct:"Post", Field:"Org", Type:"*b.Org", Marshal:"id"},
+  }
+  
+  g := buildGraph(fields, true)
+  assert.Equal(t, [][]string{{"a.Post", "a.User"}}, g.Cycles)
+  if assert.Len(t, g.Edges, 5) {
+    for _, e := range g.Edges {
+      assert.Equal(t, e.To == "a.User" || e.To == "a.Post", e.Cycle, e.Label())
+    }
+    assert.Equal(t, "b.Org", g.Edges[4].To)
+    assert.True(t, g.Edges[4].CrossPackage)
+  }
+  
+  g = buildGraph(fields, false)
+  assert.Len(t, g.Edges, 4)
+  assert.Len(t, g.Nodes, 3)
+  
+  for _, e := range []string{graphDOT, graphMermaid, graphJSON} {
+    var b bytes.Buffer
+    assert.Nil(t, writeGraph(&b, g, e), e)
+    assert.Contains(t, b.String(), "Author", e)
+  }
+  assert.NotNil(t, writeGraph(&bytes.Buffer{}, g, "svg"))
+}
+
+func TestBuildGraphPackages(t *testing.T) {
+  posts := []listField{
+    {Package:"models", Path:"x/posts/models", Struct:"Post", Field:"Author", Type:"*models.User", TypePath:"x/users/models", Marshal:"id"},
+    {Package:"models", Path:"x/posts/models", Struct:"Post", Field:"Reply", Type:"*Post", TypePath:"x/posts/models", Marshal:"id"},
+  }
+  users := []listField{
+    {Package:"models", Path:"x/users/models", Struct:"User", Field:"Org", Type:"*orgs.Org", TypePath:"x/orgs", Marshal:"id"},
+  }
+  
+  // packages with the same name are kept apart, and a type is external only
+  // if its package isn't listed, whichever order they're listed in
+  for _, fields := range [][]listField{append(append([]listField{}, posts...), users...), append(append([]listField{}, users...), posts...)} {
+    g := buildGraph(fields, true)
+    nodes := make(map[string]bool)
+    for _, e := range g.Nodes {
+      nodes[e.Id] = e.External
+      assert.Equal(t, e.Name != "Org", e.Package == "models", e.Id)
+    }
+    assert.Equal(t, map[string]bool{"x/posts/models.Post":false, "x/users/models.User":false, "x/orgs.Org":true}, nodes)
+    assert.Equal(t, [][]string{{"x/posts/models.Post"}}, g.Cycles)
+    
+    g = buildGraph(fields, false)
+    if assert.Len(t, g.Edges, 1) {
+      assert.Equal(t, "x/posts/models.Post", g.Edges[0].To)
+    }
+  }
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+  "io"
+  "fmt"
+  "strings"
+  "reftag"
+)
+
+/**
+ * The wait before retrying a request when a resolver's Backoff is zero
+ */
+const defaultBackoff = "100 * ref_time.Millisecond"
+
+/**
+ * Generate the HTTP resolver for a ref type, if its referenced type is mapped
+ * to a URL template by a ref tag or the configuration. A template with {id}
+ * is fetched once per id and the response is the value; one with {ids} is
+ * fetched per batch and the response is an array of values, which are
+ * matched to refs by the id the resolver's IdOf reports or, by default, by
+ * their Id or ID field.
+ */
+func genHTTPResolver(cxt *context, w io.Writer, ref *refType) error {
+  id := ref.Ident
+  n := strings.TrimLeft(id.Name, "*")
+  tmpl, tagged := cxt.URLs[n]
+  if !tagged {
+    tmpl = httpURLs[n]
+  }
+  if tmpl == "" {
+    return nil
+  }
+  if id.Dims > 0 || id.Key != nil || id.Inds > 1 {
+    if tagged {
+      return fmt.Errorf("HTTP resolver for %v requires a referenced type that is neither a slice nor a map", id.Name)
+    }
+    return nil // the configuration applies to every package
+  }
+  err := reftag.CheckURL(tmpl)
+  if err != nil {
+    return err
+  }
+  
+  // how values are requested and matched to the refs that share their id
+  var fetch, fields, doc string
+  if strings.Contains(tmpl, reftag.URLIds) {
+    doc = `
+//
+// Ids are requested BatchSize at a time, or all at once if it is zero, and
+// the response is an array of values. Each is matched to refs by the id IdOf
+// returns for it or, if IdOf is nil, by its Id or ID field, compared with ids
+// as text. Values that match no ref are ignored.`
+    fields = fmt.Sprintf(`
+  IdOf      func(*%[1]v) %[2]v`,
+    n, idType)
+    fetch = fmt.Sprintf(`
+  // values are matched by their id as it appears in the URL
+  byKey := make(map[string][]*%[4]v)
+  for id, e := range byId {
+    byKey[ref_fmt.Sprint(id)] = e
+  }
+  n := r.BatchSize
+  if n < 1 {
+    n = len(ids)
+  }
+  for len(ids) > 0 {
+    b := ids
+    if len(b) > n {
+      b = b[:n]
+    }
+    ids = ids[len(b):]
+    
+    p := make([]string, len(b))
+    for i, e := range b {
+      p[i] = ref_url.QueryEscape(ref_fmt.Sprint(e))
+    }
+    var vals []*%[1]v
+    _, err := r.get(ctx, ref_strings.Replace(%[2]q, %[3]q, ref_strings.Join(p, ","), -1), &vals)
+    if err != nil {
+      return err
+    }
+    for _, v := range vals {
+      if v == nil {
+        continue
+      }
+      var id string
+      if r.IdOf != nil {
+        id = ref_fmt.Sprint(r.IdOf(v))
+      }else if x := ref_reflect.Indirect(ref_reflect.ValueOf(v)); x.Kind() == ref_reflect.Struct {
+        for _, n := range []string{"Id", "ID"} {
+          if f := x.FieldByName(n); f.IsValid() {
+            id = ref_fmt.Sprint(f.Interface())
+            break
+          }
+        }
+      }
+      for _, e := range byKey[id] {
+        e.Value = v
+      }
+    }
+  }
+  return nil`,
+    n, tmpl, reftag.URLIds, ref.Name)
+  }else{
+    fetch = fmt.Sprintf(`
+  for _, id := range ids {
+    v := &%[1]v{}
+    found, err := r.get(ctx, ref_strings.Replace(%[2]q, %[3]q, ref_url.PathEscape(ref_fmt.Sprint(id)), -1), v)
+    if err != nil {
+      return err
+    }
+    if found {
+      for _, e := range byId[id] {
+        e.Value = v
+      }
+    }
+  }
+  return nil`,
+    n, tmpl, reftag.URLId)
+  }
+  
+  refId := ref.Name
+  rspec := fmt.Sprintf(`
+// %[1]v%[4]v fetches the %[3]vs that %[1]vs refer to over HTTP from
+// %[5]v, relative to BaseURL. Requests use Client, or the default client if
+// it is nil. Failed requests that may succeed later (network errors, 429 and
+// 5xx responses) are retried up to Retries times, waiting Backoff, or 100ms if
+// it is zero, and then twice as long each time. A 404 leaves refs without a
+// value.%[9]v
+type %[1]v%[4]v struct {
+  Client    *ref_http.Client
+  BaseURL   string
+  Retries   int
+  Backoff   ref_time.Duration
+  BatchSize int%[7]v
+}
+
+func New%[1]v%[4]v(base string) *%[1]v%[4]v {
+  return &%[1]v%[4]v{BaseURL:base, Retries:2, Backoff:%[8]v}
+}
+
+// Resolve fetches the value of every ref that has none, requesting each
+// distinct id once. Refs that share an id share the value.
+func (r *%[1]v%[4]v) Resolve(ctx ref_context.Context, refs ...*%[1]v) error {
+  byId := make(map[%[2]v][]*%[1]v)
+  var ids []%[2]v
+  for _, e := range refs {
+    if e == nil || e.Value != nil {
+      continue
+    }
+    if _, ok := byId[e.Id]; !ok {
+      ids = append(ids, e.Id)
+    }
+    byId[e.Id] = append(byId[e.Id], e)
+  }
+  %[6]v
+}
+
+func (r *%[1]v%[4]v) get(ctx ref_context.Context, u string, v interface{}) (bool, error) {
+  wait := r.Backoff
+  if wait <= 0 {
+    wait = %[8]v
+  }
+  for i := 0; ; i++ {
+    found, retry, err := r.fetch(ctx, r.BaseURL + u, v)
+    if err == nil || !retry || i >= r.Retries {
+      return found, err
+    }
+    select {
+      case <-ctx.Done():
+        return false, ctx.Err()
+      case <-ref_time.After(wait):
+        wait *= 2
+    }
+  }
+}
+
+func (r *%[1]v%[4]v) fetch(ctx ref_context.Context, u string, v interface{}) (bool, bool, error) {
+  req, err := ref_http.NewRequestWithContext(ctx, ref_http.MethodGet, u, nil)
+  if err != nil {
+    return false, false, err
+  }
+  req.Header.Set("Accept", "application/json")
+  
+  c := r.Client
+  if c == nil {
+    c = ref_http.DefaultClient
+  }
+  rsp, err := c.Do(req)
+  if err != nil {
+    return false, ctx.Err() == nil, err
+  }
+  defer rsp.Body.Close()
+  
+  switch {
+    case rsp.StatusCode == ref_http.StatusNotFound:
+      return false, false, nil
+    case rsp.StatusCode == ref_http.StatusTooManyRequests || rsp.StatusCode >= 500:
+      return false, true, ref_fmt.Errorf("GET %%v: %%v", u, rsp.Status)
+    case rsp.StatusCode < 200 || rsp.StatusCode > 299:
+      return false, false, ref_fmt.Errorf("GET %%v: %%v", u, rsp.Status)
+  }
+  err = ref_json.NewDecoder(rsp.Body).Decode(v)
+  if err != nil {
+    return false, false, ref_fmt.Errorf("GET %%v: %%v", u, err)
+  }
+  return true, false, nil
+}`,
+  refId, idType, n, httpResolverSuffix, tmpl, strings.TrimSpace(fetch), fields, defaultBackoff, doc)
+  
+  if lazyRefs {
+    rspec += resolverLoader(refId, httpResolverSuffix, n, "value", "an error", fmt.Sprintf("ref_fmt.Errorf(\"No %v with id: %%v\", id)", n))
+  }
+  
+  fmt.Fprint(w, "\n"+ strings.TrimSpace(rspec) +"\n")
+  return nil
+}
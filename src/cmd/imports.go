@@ -0,0 +1,242 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "strings"
+  "strconv"
+  "unicode"
+  "go/ast"
+  "go/build"
+  "go/token"
+  "go/parser"
+  "golang.org/x/tools/go/ast/astutil"
+)
+
+/**
+ * Package names we have resolved, by import path
+ */
+var packageNames = make(map[string]string)
+
+/**
+ * Determine the name of the package at an import path. We ask the build
+ * system first and fall back to the name it conventionally has, which is
+ * what goimports does when a package can't be found.
+ */
+func packageName(p string) string {
+  if n, ok := packageNames[p]; ok {
+    return n
+  }
+  
+  n := assumedPackageName(p)
+  if wd, err := os.Getwd(); err == nil {
+    if pkg, err := build.Default.Import(p, wd, 0); err == nil && pkg.Name != "" {
+      n = pkg.Name
+    }
+  }
+  
+  packageNames[p] = n
+  return n
+}
+
+/**
+ * The name a package at an import path is assumed to have: the last path
+ * element, without any major version suffix, "go-" prefix or characters
+ * that can't appear in an identifier.
+ */
+func assumedPackageName(p string) string {
+  parts := strings.Split(p, "/")
+  n := parts[len(parts) - 1]
+  if len(parts) > 1 && isMajorVersion(n) {
+    n = parts[len(parts) - 2]
+  }
+  if x := strings.LastIndex(n, "."); x > 0 && isMajorVersion(n[x+1:]) {
+    n = n[:x] // gopkg.in/yaml.v3
+  }
+  n = strings.TrimPrefix(n, "go-")
+  if x := strings.IndexFunc(n, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' }); x > 0 {
+    n = n[:x]
+  }
+  return n
+}
+
+func isMajorVersion(v string) bool {
+  if len(v) < 2 || v[0] != 'v' {
+    return false
+  }
+  _, err := strconv.Atoi(v[1:])
+  return err == nil
+}
+
+/**
+ * Determine if an import path is in the standard library
+ */
+func isStdImport(p string) bool {
+  first := p
+  if x := strings.Index(p, "/"); x > -1 {
+    first = p[:x]
+  }
+  return !strings.Contains(first, ".")
+}
+
+/**
+ * Compile the set of package names a file refers to. A qualified identifier
+ * whose qualifier doesn't resolve to anything declared in the file must be
+ * a package, since an imported name can't also be declared at package
+ * scope.
+ */
+func usedPackages(file *ast.File) map[string]bool {
+  used := make(map[string]bool)
+  for _, d := range file.Decls {
+    if g, ok := d.(*ast.GenDecl); ok && g.Tok == token.IMPORT {
+      continue
+    }
+    ast.Inspect(d, func(n ast.Node) bool {
+      if s, ok := n.(*ast.SelectorExpr); ok {
+        if id, ok := s.X.(*ast.Ident); ok && id.Obj == nil {
+          used[id.Name] = true
+        }
+      }
+      return true
+    })
+  }
+  return used
+}
+
+/**
+ * Remove imports a file no longer uses and sort what's left within its
+ * groups. Blank, dot and cgo imports are always kept since we can't tell if
+ * they're used by looking.
+ */
+func trimImports(fset *token.FileSet, file *ast.File) {
+  used := usedPackages(file)
+  for _, e := range append([]*ast.ImportSpec(nil), file.Imports...) {
+    p := importPath(e)
+    if p == "C" || (e.Name != nil && (e.Name.Name == "_" || e.Name.Name == ".")) {
+      continue
+    }
+    if !used[importPackage(e)] {
+      var name string
+      if e.Name != nil {
+        name = e.Name.Name
+      }
+      astutil.DeleteNamedImport(fset, file, name, p)
+    }
+  }
+  ast.SortImports(fset, file)
+}
+
+/**
+ * Produce an import declaration for a set of imports, grouped into standard
+ * library and other imports and sorted by path within each group. Explicit
+ * names are preserved and added where the package name can't be inferred
+ * from its path.
+ */
+func importDecl(specs []*ast.ImportSpec) string {
+  if len(specs) < 1 {
+    return ""
+  }
+  
+  var std, other []*ast.ImportSpec
+  for _, e := range specs {
+    if isStdImport(importPath(e)) {
+      std = append(std, e)
+    }else{
+      other = append(other, e)
+    }
+  }
+  
+  var groups []string
+  for _, g := range [][]*ast.ImportSpec{std, other} {
+    if len(g) < 1 {
+      continue
+    }
+    sort.SliceStable(g, func(i, j int) bool {
+      return importPath(g[i]) < importPath(g[j])
+    })
+    var lines string
+    for _, e := range g {
+      p := importPath(e)
+      if e.Name != nil {
+        lines += "  "+ e.Name.Name +" "+ strconv.Quote(p) +"\n"
+      }else if n := packageName(p); n != assumedPackageName(p) {
+        lines += "  "+ n +" "+ strconv.Quote(p) +"\n"
+      }else{
+        lines += "  "+ strconv.Quote(p) +"\n"
+      }
+    }
+    groups = append(groups, lines)
+  }
+  
+  return "import (\n"+ strings.Join(groups, "\n") +")\n"
+}
+
+func importPath(e *ast.ImportSpec) string {
+  return stringLit(e.Path)
+}
+
+/**
+ * Imports used by generated code, under names that can't collide with the
+ * package's own imports
+ */
+var refImports = []*ast.ImportSpec{
+  namedImport("ref_fmt", "fmt"),
+  namedImport("ref_reflect", "reflect"),
+  namedImport("ref_json", "encoding/json"),
+  namedImport("ref_context", "context"),
+  namedImport("ref_sync", "sync"),
+  namedImport("ref_driver", "database/sql/driver"),
+  namedImport("ref_sql", "database/sql"),
+  namedImport("ref_strings", "strings"),
+  namedImport("ref_http", "net/http"),
+  namedImport("ref_url", "net/url"),
+  namedImport("ref_time", "time"),
+  namedImport("ref_list", "container/list"),
+}
+
+func namedImport(name, p string) *ast.ImportSpec {
+  return &ast.ImportSpec{Name:ast.NewIdent(name), Path:&ast.BasicLit{Kind:token.STRING, Value:strconv.Quote(p)}}
+}
+
+/**
+ * Produce the import declaration for generated code. Everything the code
+ * refers to must be importable: our own imports, the dependencies of the
+ * types we generate for, and anything imported by the package's sources
+ * (which is where an identifier type's package is usually found).
+ */
+func generatedImports(cxt *context, body []byte) (string, error) {
+  file, err := parser.ParseFile(token.NewFileSet(), "", "package "+ cxt.Package +"\n"+ string(body), 0)
+  if err != nil {
+    return "", err
+  }
+  
+  avail := make(importSet)
+  for k, v := range cxt.Imports {
+    avail[k] = v
+  }
+  for k, v := range cxt.Deps {
+    avail[k] = v
+  }
+  for _, e := range refImports {
+    avail.Add(e)
+  }
+  
+  used := usedPackages(file)
+  names := make([]string, 0, len(used))
+  for k := range used {
+    names = append(names, k)
+  }
+  sort.Strings(names)
+  
+  var specs []*ast.ImportSpec
+  for _, e := range names {
+    m, ok := avail[e]
+    if !ok {
+      return "", fmt.Errorf("No import found for package referenced by generated code: %v (provide one with -import)", e)
+    }
+    specs = append(specs, m)
+  }
+  
+  return importDecl(specs), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+  "fmt"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestGeneratedImports(t *testing.T) {
+  cxt := newContext("a", nil, optionNone)
+  cxt.Imports.Add(namedImport("json", "github.com/goccy/go-json"))
+  cxt.Imports.Add(namedImport("u", "net/url"))
+  cxt.Imports.Add(namedImport("strings", "strings"))
+  
+  // the package's json is a different package from the one we use, which
+  // is imported as ref_json; strings is never used by generated code
+  imps, err := generatedImports(cxt, []byte(`
+func (r *PostRef) MarshalJSON() ([]byte, error) {
+  return ref_json.Marshal(r.ID)
+}
+func (r *PostRef) Decode(b []byte) error {
+  return json.Unmarshal(b, &r.ID)
+}
+func (r *PostRef) URL() *u.URL {
+  return nil
+}
+`))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, `import (
+  ref_json "encoding/json"
+  u "net/url"
+
+  json "github.com/goccy/go-json"
+)
+`, imps)
+  }
+  
+  // nothing used, nothing imported
+  imps, err = generatedImports(cxt, []byte("type PostRef struct{}\n"))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, "", imps)
+  }
+  
+  // a package generated code refers to that nothing provides
+  _, err = generatedImports(cxt, []byte("var _ yaml.Node\n"))
+  assert.EqualError(t, err, "No import found for package referenced by generated code: yaml (provide one with -import)")
+}
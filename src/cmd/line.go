@@ -0,0 +1,82 @@
+package main
+
+import (
+  "io"
+  "fmt"
+  "bytes"
+  "strings"
+  "go/ast"
+  "go/token"
+  "go/printer"
+  "path/filepath"
+)
+
+/**
+ * Placeholder for a directive that restores positions to the generated file
+ * itself. We can't know which line it lands on until the file is assembled,
+ * so it's resolved then.
+ */
+const lineReset = "//goref:line-reset"
+
+/**
+ * Determine the name a source file is referred to by in line directives.
+ * Generated files normally sit beside their sources, so the base name is
+ * enough (and keeps paths out of the output); overlay files are elsewhere
+ * and need the absolute path.
+ */
+func lineFile(src string) string {
+  if overlayDir != "" {
+    if abs, err := filepath.Abs(src); err == nil {
+      return abs
+    }
+  }
+  return filepath.Base(src)
+}
+
+func lineDirective(file string, line int) string {
+  return fmt.Sprintf("//line %s:%d", file, line)
+}
+
+/**
+ * Produce the directive mapping the next line to the declaration of a type
+ */
+func declLine(fset *token.FileSet, spec *ast.TypeSpec) string {
+  p := fset.Position(spec.Pos())
+  return lineDirective(lineFile(p.Filename), p.Line) +"\n"
+}
+
+/**
+ * Print a rewritten source, formatted as gofmt would, with line directives
+ * mapping it back to the original. The printer only emits a directive where
+ * its output drifts from the source, which it can't know our header causes,
+ * so we make sure the first line is aligned.
+ */
+func printSourceLines(output io.Writer, fset *token.FileSet, file *ast.File, src string) error {
+  buf := &bytes.Buffer{}
+  err := (&printer.Config{Tabwidth: 8, Mode: printer.UseSpaces | printer.TabIndent | printer.SourcePos}).Fprint(buf, fset, file)
+  if err != nil {
+    return err
+  }
+  
+  name := lineFile(src)
+  text := strings.Replace(buf.String(), "//line "+ src +":", "//line "+ name +":", -1)
+  if !strings.HasPrefix(text, "//line ") {
+    text = lineDirective(name, 1) +"\n"+ text
+  }
+  _, err = fmt.Fprint(output, text)
+  return err
+}
+
+/**
+ * Replace reset placeholders in a generated file with directives pointing
+ * at the line that follows them.
+ */
+func resolveLineResets(data []byte, file string) []byte {
+  lines := strings.SplitAfter(string(data), "\n")
+  for i, e := range lines {
+    if strings.TrimSuffix(e, "\n") == lineReset {
+      lines[i] = lineDirective(lineFile(file), i + 2) +"\n"
+    }
+  }
+  return []byte(strings.Join(lines, ""))
+}
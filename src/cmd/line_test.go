@@ -0,0 +1,58 @@
+package main
+
+import (
+  "fmt"
+  "testing"
+  "go/ast"
+  "go/token"
+  "go/parser"
+  "go/format"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestResolveLineResets(t *testing.T) {
+  // formatting collapses blank lines and rewrites spacing, which moves
+  // everything after it, so resets can't be resolved any earlier
+  src := "package a\n\n\n\n"+
+    lineDirective("a.go", 12) +"\n"+
+    "type PostRef struct {\n  ID   int\n}\n"+
+    lineReset +"\n"+
+    "func (r PostRef)   Get( )  int {\n\n\n  return r.ID\n}\n\n\n\n"+
+    lineDirective("a.go", 20) +"\n"+
+    "type UserRef struct{ ID int }\n"+
+    lineReset +"\n"+
+    "func (r UserRef) Get() int { return r.ID }\n"
+  
+  data, err := format.Source([]byte(src))
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  data = resolveLineResets(data, "/tmp/a/pkg_ref.go")
+  
+  fset := token.NewFileSet()
+  file, err := parser.ParseFile(fset, "pkg_ref.go", data, parser.ParseComments)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  // types map to their sources, methods to where they are in the output
+  expect := map[string]string{
+    "PostRef": "a.go:12",
+    "UserRef": "a.go:20",
+  }
+  for _, d := range file.Decls {
+    var name string
+    switch v := d.(type) {
+      case *ast.GenDecl:
+        name = v.Specs[0].(*ast.TypeSpec).Name.Name
+      case *ast.FuncDecl:
+        name = v.Recv.List[0].Type.(*ast.Ident).Name +"."+ v.Name.Name
+    }
+    e, ok := expect[name]
+    if !ok {
+      e = fmt.Sprintf("pkg_ref.go:%d", fset.PositionFor(d.Pos(), false).Line)
+    }
+    p := fset.Position(d.Pos())
+    assert.Equal(t, e, fmt.Sprintf("%s:%d", p.Filename, p.Line), name)
+  }
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+  "io"
+  "fmt"
+  "sort"
+  "strings"
+  "go/ast"
+  "go/build"
+  "go/token"
+  "path/filepath"
+  "encoding/json"
+  "text/tabwriter"
+  "reftag"
+)
+
+/**
+ * A ref field, as listed
+ */
+type listField struct {
+  Package   string    `json:"package"`
+  Path      string    `json:"path,omitempty"`
+  Struct    string    `json:"struct"`
+  Field     string    `json:"field"`
+  Type      string    `json:"type"`
+  TypePath  string    `json:"type_path,omitempty"`
+  Ref       string    `json:"ref"`
+  IdKey     string    `json:"id_key"`
+  ValueKey  string    `json:"value_key"`
+  Marshal   string    `json:"marshal"`
+  OmitEmpty bool      `json:"omitempty"`
+  Source    string    `json:"source"`
+}
+
+/**
+ * List every ref field in the packages in a directory
+ */
+func listDir(dir string, opts options) ([]listField, error) {
+  var fields []listField
+  err := inspectDir(dir, opts, func(cxt *context, fset *token.FileSet) error {
+    f, err := listPackage(cxt, fset)
+    fields = append(fields, f...)
+    return err
+  })
+  return fields, err
+}
+
+/**
+ * Process the packages in a directory exactly as they are for generation,
+ * but write nothing, and pass each to a function that inspects the result
+ */
+func inspectDir(dir string, opts options, fn func(*context, *token.FileSet) error) error {
+  fset, pkgs, err := parseDir(dir)
+  if err != nil {
+    return err
+  }
+  
+  pnames := make([]string, 0, len(pkgs))
+  for k := range pkgs {
+    pnames = append(pnames, k)
+  }
+  sort.Strings(pnames)
+  
+  for _, pname := range pnames {
+    cxt, err := inspectPackage(dir, fset, pkgs[pname], opts)
+    if err != nil {
+      return err
+    }
+    
+    err = fn(cxt, fset)
+    if err != nil {
+      return err
+    }
+  }
+  
+  return nil
+}
+
+/**
+ * Process a package exactly as it is for generation, but write nothing
+ */
+func inspectPackage(dir string, fset *token.FileSet, pkg *ast.Package, opts options) (*context, error) {
+  cxt := newContext(pkg.Name, extraImports, opts)
+  cxt.Dir = dir
+  if sharing() {
+    err := prepareShared(cxt, dir)
+    if err != nil {
+      return nil, err
+    }
+  }else if p, err := packageImportPath(dir); err == nil {
+    cxt.ImportPath = p // identifies the package, where it can be determined
+  }
+  
+  fnames := make([]string, 0, len(pkg.Files))
+  for k := range pkg.Files {
+    fnames = append(fnames, k)
+  }
+  sort.Strings(fnames)
+  
+  for _, e := range fnames {
+    err := procAST(cxt, fset, pkg.Name, e, refFile(e), pkg.Files[e], false)
+    if err != nil {
+      return nil, err
+    }
+  }
+  err := resolveNames(cxt)
+  if err != nil {
+    return nil, err
+  }
+  return cxt, nil
+}
+
+/**
+ * List the ref fields of every struct in a processed package
+ */
+func listPackage(cxt *context, fset *token.FileSet) ([]listField, error) {
+  specs, err := marshalSpecs(cxt)
+  if err != nil {
+    return nil, err
+  }
+  
+  var fields []listField
+  for _, spec := range specs {
+    base, ok := spec.Type.(*ast.StructType)
+    if !ok {
+      continue
+    }
+    f, err := listStruct(cxt, fset, spec, base, "")
+    if err != nil {
+      return nil, err
+    }
+    fields = append(fields, f...)
+  }
+  return fields, nil
+}
+
+/**
+ * The structs in a processed package that have ref fields, in the order they
+ * are declared
+ */
+func marshalSpecs(cxt *context) ([]*ast.TypeSpec, error) {
+  specs := make([]*ast.TypeSpec, 0, len(cxt.Marshal))
+  for k := range cxt.Marshal {
+    spec, ok := cxt.Types[k]
+    if !ok {
+      return nil, fmt.Errorf("No type found for: %s", k)
+    }
+    specs = append(specs, spec)
+  }
+  sort.Slice(specs, func(i, j int) bool {
+    return specs[i].Pos() < specs[j].Pos()
+  })
+  return specs, nil
+}
+
+/**
+ * List the ref fields of a struct, including those of the anonymous structs
+ * declared within it, which are named by their path from the outer struct
+ */
+func listStruct(cxt *context, fset *token.FileSet, spec *ast.TypeSpec, base *ast.StructType, prefix string) ([]listField, error) {
+  if base.Fields == nil {
+    return nil, nil
+  }
+  
+  var fields []listField
+  for _, e := range base.Fields.List {
+    if a, _, ok := anonStruct(e.Type); ok {
+      for _, v := range e.Names {
+        f, err := listStruct(cxt, fset, spec, a, prefix + v.Name +".")
+        if err != nil {
+          return nil, err
+        }
+        fields = append(fields, f...)
+      }
+      continue
+    }
+    
+    ftype, err := parseIdent(e.Type)
+    if err != nil {
+      return nil, err
+    }
+    qual, name := refTypeName(ftype)
+    ref, ok := cxt.Lookup[qual + name]
+    if !ok {
+      continue // not a ref field
+    }
+    path := cxt.ImportPath
+    if q, _ := graphType(ref.Name); q != "" {
+      path = q
+      if m, ok := cxt.Imports[q]; ok {
+        path = stringLit(m.Path)
+      }
+    }
+    for _, v := range e.Names {
+      policy, err := reftag.Parse(e, v.Name)
+      if err != nil {
+        return nil, err
+      }
+      if policy.Omit || !policy.Ref {
+        continue
+      }
+      fields = append(fields, listField{
+        Package:   cxt.Package,
+        Path:      cxt.ImportPath,
+        Struct:    spec.Name.Name,
+        Field:     prefix + v.Name,
+        Type:      ref.Name,
+        TypePath:  path,
+        Ref:       qual + name,
+        IdKey:     policy.Names.Id,
+        ValueKey:  policy.Names.Value,
+        Marshal:   policy.Marshal.String(),
+        OmitEmpty: policy.OmitEmpty,
+        Source:    fset.Position(v.Pos()).String(),
+      })
+    }
+  }
+  
+  return fields, nil
+}
+
+/**
+ * Write a listing as a table
+ */
+func writeListTable(w io.Writer, fields []listField) error {
+  t := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+  fmt.Fprintln(t, "PACKAGE\tSTRUCT\tFIELD\tTYPE\tREF\tID KEY\tVALUE KEY\tMARSHAL")
+  for _, e := range fields {
+    fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Package, e.Struct, e.Field, e.Type, e.Ref, e.IdKey, e.ValueKey, e.Marshal)
+  }
+  return t.Flush()
+}
+
+/**
+ * Write a listing as JSON
+ */
+func writeListJSON(w io.Writer, fields []listField) error {
+  if fields == nil {
+    fields = []listField{}
+  }
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  return enc.Encode(fields)
+}
+
+/**
+ * Load another package by its import path, as it's resolved from the
+ * directory of a package. It is processed as it would be for generation,
+ * since its sources may only be built once they have been. A package that
+ * can't be found is nil.
+ */
+func loadPackage(cxt *context, p string) (*context, error) {
+  var err error
+  bcxt := build.Default
+  bcxt.Dir, err = filepath.Abs(cxt.Dir)
+  if err != nil {
+    return nil, err
+  }
+  pkg, err := bcxt.Import(p, bcxt.Dir, build.FindOnly)
+  if err != nil {
+    return nil, nil
+  }
+  fset, pkgs, err := parseDir(pkg.Dir)
+  if err != nil {
+    return nil, err
+  }
+  
+  pnames := make([]string, 0, len(pkgs))
+  for k := range pkgs {
+    pnames = append(pnames, k)
+  }
+  sort.Strings(pnames)
+  for _, e := range pnames {
+    if !strings.HasSuffix(e, "_test") {
+      return inspectPackage(pkg.Dir, fset, pkgs[e], cxt.Options)
+    }
+  }
+  return nil, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "bytes"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestListJSON(t *testing.T) {
+  dir := writePackage(t, map[string]string{"a.go": `package a
+  
+type User struct {
+  Id      string  `+"`json:\"id\"`"+`
+}
+
+type Post struct {
+  Title   string  `+"`json:\"title\"`"+`
+  Author  *User   `+"`json:\"author,omitempty\" ref:\"author_id\"`"+`
+  Editors []*User `+"`json:\"editors\" ref:\"editor_ids,value\"`"+`
+  Meta    struct {
+    Owner *User   `+"`json:\"owner\" ref:\"owner_id\"`"+`
+  }               `+"`json:\"meta\"`"+`
+}
+`})
+  applySettings(t, settings{})
+  
+  // list from inside the directory so sources are relative
+  wd, err := os.Getwd()
+  if !assert.Nil(t, err) {
+    return
+  }
+  defer os.Chdir(wd)
+  assert.Nil(t, os.Chdir(dir))
+  
+  fields, err := listDir(".", optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  var b bytes.Buffer
+  if assert.Nil(t, writeListJSON(&b, fields)) {
+    assert.Equal(t, `[
+  {
+    "package": "a",
+    "struct": "Post",
+    "field": "Author",
+    "type": "*User",
+    "ref": "UserRef",
+    "id_key": "author_id",
+    "value_key": "author",
+    "marshal": "id",
+    "omitempty": true,
+    "source": "a.go:9:3"
+  },
+  {
+    "package": "a",
+    "struct": "Post",
+    "field": "Editors",
+    "type": "[]*User",
+    "ref": "ArrayOfPtrToUserRef",
+    "id_key": "editor_ids",
+    "value_key": "editors",
+    "marshal": "value",
+    "omitempty": false,
+    "source": "a.go:10:3"
+  },
+  {
+    "package": "a",
+    "struct": "Post",
+    "field": "Meta.Owner",
+    "type": "*User",
+    "ref": "UserRef",
+    "id_key": "owner_id",
+    "value_key": "owner",
+    "marshal": "id",
+    "omitempty": false,
+    "source": "a.go:12:5"
+  }
+]
+`, b.String())
+  }
+  
+  b.Reset()
+  if assert.Nil(t, writeListJSON(&b, nil)) {
+    assert.Equal(t, "[]\n", b.String())
+  }
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+  "strconv"
+  "go/ast"
+  "go/parser"
+  "reftag"
+  "golang.org/x/tools/go/ast/astutil"
+)
+
+/**
+ * The name of the routine generated code uses to test for empty values,
+ * unless the package declares something by that name itself
+ */
+const isEmptyFunc = "isEmptyValue"
+
+/**
+ * A ref type to be generated
+ */
+type refType struct {
+  Ident   *ident          // the referenced type
+  Name    string          // the name of the generated type, once resolved
+  Pinned  bool            // the name was provided by a tag
+  Uses    []*ast.Ident    // rewritten field types that refer to it
+}
+
+/**
+ * Ref types, keyed by the type of their value and any pinned name
+ */
+type refSet map[string]*refType
+
+/**
+ * Add a use of the ref type for an ident to the set and return the
+ * identifier the field should refer to it by. The identifier is named for
+ * real once every ref type in the package is known.
+ */
+func (s refSet) Add(id *ident, pinned string) *ast.Ident {
+  key := valueType(id)
+  if pinned != "" {
+    key += "="+ pinned
+  }
+  
+  r, ok := s[key]
+  if !ok {
+    r = &refType{Ident:id, Name:pinned, Pinned:pinned != ""}
+    s[key] = r
+  }
+  
+  use := ast.NewIdent(r.Name)
+  if use.Name == "" {
+    use.Name = id.Base + refSuffix
+  }
+  r.Uses = append(r.Uses, use)
+  return use
+}
+
+/**
+ * Obtain the ref types in the set, ordered by name
+ */
+func (s refSet) Sorted() []*refType {
+  keys := make([]string, 0, len(s))
+  for k := range s {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  
+  refs := make([]*refType, len(keys))
+  for i, e := range keys {
+    refs[i] = s[e]
+  }
+  sort.SliceStable(refs, func(i, j int) bool {
+    return refs[i].Name < refs[j].Name
+  })
+  return refs
+}
+
+/**
+ * The type of a ref's value. Values are always nullable, so T and *T share
+ * a ref type.
+ */
+func valueType(id *ident) string {
+  var inds int
+  if !id.Nullable() {
+    inds++
+  }
+  return repeat(inds, '*') + id.Name
+}
+
+/**
+ * Names declared at package scope
+ */
+type nameSet map[string]struct{}
+
+func (s nameSet) AddDecl(d ast.Decl) {
+  switch v := d.(type) {
+    case *ast.FuncDecl:
+      if v.Recv == nil {
+        s[v.Name.Name] = struct{}{}
+      }
+    case *ast.GenDecl:
+      for _, e := range v.Specs {
+        switch t := e.(type) {
+          case *ast.TypeSpec:
+            s[t.Name.Name] = struct{}{}
+          case *ast.ValueSpec:
+            for _, n := range t.Names {
+              s[n.Name] = struct{}{}
+            }
+        }
+      }
+  }
+}
+
+/**
+ * Record a method by its receiver's type, as Type.Method
+ */
+func (s nameSet) AddMethod(d ast.Decl) {
+  v, ok := d.(*ast.FuncDecl)
+  if !ok || v.Recv == nil || len(v.Recv.List) != 1 {
+    return
+  }
+  x := v.Recv.List[0].Type
+  if t, ok := x.(*ast.StarExpr); ok {
+    x = t.X
+  }
+  switch t := x.(type) {
+    case *ast.IndexExpr:
+      x = t.X
+    case *ast.IndexListExpr:
+      x = t.X
+  }
+  if t, ok := x.(*ast.Ident); ok {
+    s[t.Name +"."+ v.Name.Name] = struct{}{}
+  }
+}
+
+/**
+ * Ways of naming a ref type, from the plainest to the most qualified. A
+ * type is given the first name that nothing else in the package wants.
+ */
+var refNamers = []func(*ident) (string, error){
+  func(id *ident) (string, error) {
+    return id.Base + refSuffix, nil
+  },
+  func(id *ident) (string, error) {
+    return qualifiedRefName(id, false)
+  },
+  func(id *ident) (string, error) {
+    return qualifiedRefName(id, true)
+  },
+}
+
+/**
+ * Name a ref type including the packages its type comes from, e.g.
+ * JsonRawMessageRef. Indirection is also spelled out if requested.
+ */
+func qualifiedRefName(id *ident, r bool) (string, error) {
+  e, err := parser.ParseExpr(id.Name)
+  if err != nil {
+    return "", err
+  }
+  e = astutil.Apply(e, func(c *astutil.Cursor) bool {
+    if v, ok := c.Node().(*ast.SelectorExpr); ok {
+      p, s, _, err := concatIdent(v, 0)
+      if err == nil {
+        var n string
+        for _, x := range strings.Split(p, ".") {
+          n += strings.Title(x)
+        }
+        c.Replace(ast.NewIdent(n + s))
+        return false
+      }
+    }
+    return true
+  }, nil).(ast.Expr)
+  
+  d, err := parseIdentE(e, r)
+  if err != nil {
+    return "", err
+  }
+  return strings.Title(d.Base) + refSuffix, nil
+}
+
+/**
+ * The identifiers generated for a ref type
+ */
+func refNames(n string) []string {
+  names := []string{n, "New"+ n, "New"+ n + idSuffix, n + columnSuffix, "New"+ n + columnSuffix, n + resolverSuffix, "New"+ n + resolverSuffix, n + httpResolverSuffix, "New"+ n + httpResolverSuffix}
+  if lazyRefs {
+    names = append(names, n + loaderSuffix)
+  }
+  if cacheRefs {
+    names = append(names, n + cacheSuffix, "New"+ n + cacheSuffix, n + cacheSuffix +"Stats", cacheEntryName(n))
+  }
+  return names
+}
+
+/**
+ * Name everything generated for a package. Pinned ref type names are used
+ * as given; other ref types get the first name that is unique among them
+ * and doesn't collide with anything the package declares.
+ */
+func resolveNames(cxt *context) error {
+  used := make(map[string]string)
+  for k := range cxt.Decls {
+    used[k] = "a declaration in the package"
+  }
+  if refRegistry {
+    for _, e := range registryNames {
+      if _, ok := used[e]; ok {
+        return fmt.Errorf("The ref field registry declares %v, which collides with a declaration in the package", e)
+      }
+      used[e] = "the ref field registry"
+    }
+  }
+  conflict := func(n string) (string, bool) {
+    for _, e := range refNames(n) {
+      if c, ok := used[e]; ok {
+        return c, true
+      }
+    }
+    return "", false
+  }
+  claim := func(r *refType) {
+    for _, e := range refNames(r.Name) {
+      used[e] = "the ref type for "+ r.Ident.Name
+    }
+  }
+  
+  refs := cxt.Generate.Sorted()
+  var pending []*refType
+  for _, r := range refs {
+    if !r.Pinned {
+      pending = append(pending, r)
+      continue
+    }
+    if c, ok := conflict(r.Name); ok {
+      return fmt.Errorf("Pinned ref type name %v for %v collides with %v", r.Name, r.Ident.Name, c)
+    }
+    claim(r)
+  }
+  
+  for level := 0; len(pending) > 0; level++ {
+    if level >= len(refNamers) {
+      return fmt.Errorf("Cannot name the ref type for %v without a collision; pin a name with the %q ref tag option", pending[0].Ident.Name, reftag.TypeOption +"=")
+    }
+    
+    names := make([]string, len(pending))
+    count := make(map[string]int)
+    for i, r := range pending {
+      n, err := refNamers[level](r.Ident)
+      if err != nil {
+        return err
+      }
+      names[i] = n
+      count[n]++
+    }
+    
+    var next []*refType
+    for i, r := range pending {
+      n := names[i]
+      if _, ok := conflict(n); ok || count[n] > 1 {
+        next = append(next, r)
+        continue
+      }
+      r.Name = n
+    }
+    for _, r := range pending {
+      if r.Name != "" {
+        claim(r)
+      }
+    }
+    pending = next
+  }
+  
+  for _, r := range refs {
+    for _, e := range r.Uses {
+      e.Name = r.Name
+    }
+    cxt.Lookup[r.Name] = r.Ident
+  }
+  
+  cxt.IsEmpty = isEmptyFunc
+  for i := 1; ; i++ {
+    if _, ok := used[cxt.IsEmpty]; !ok {
+      break
+    }
+    cxt.IsEmpty = "ref"+ strings.Title(isEmptyFunc)
+    if i > 1 {
+      cxt.IsEmpty += strconv.Itoa(i)
+    }
+  }
+  
+  return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "os/exec"
+  "strings"
+  "testing"
+  "go/token"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+/**
+ * Resolve the names generated for a package, returning each ref type as its
+ * referenced type and name, and the name of the empty value routine
+ */
+func resolveTestNames(t *testing.T, src string) ([]string, string, error) {
+  dir := writePackage(t, map[string]string{"a.go": src})
+  applySettings(t, settings{})
+  var names []string
+  var empty string
+  err := inspectDir(dir, optionNone, func(cxt *context, fset *token.FileSet) error {
+    for _, e := range cxt.Generate.Sorted() {
+      names = append(names, e.Ident.Name +" "+ e.Name)
+    }
+    empty = cxt.IsEmpty
+    return nil
+  })
+  return names, empty, err
+}
+
+func TestResolveNames(t *testing.T) {
+  tests := []struct{
+    Name    string
+    Source  string
+    Expect  []string
+    Empty   string
+    Error   string
+  }{
+    {
+      Name:   "plain",
+      Source: "type User struct{}\ntype Post struct {\n  A *User `ref:\"a_id\"`\n  B User `ref:\"b_id\"`\n}\n",
+      Expect: []string{"*User UserRef"},
+      Empty:  "isEmptyValue",
+    },
+    {
+      Name:   "qualified",
+      Source: "import \"encoding/json\"\ntype RawMessage struct{}\ntype Post struct {\n  A json.RawMessage `ref:\"a_id\"`\n  B *RawMessage `ref:\"b_id\"`\n}\n",
+      Expect: []string{"json.RawMessage JsonRawMessageRef", "*RawMessage RawMessageRef"},
+      Empty:  "isEmptyValue",
+    },
+    {
+      Name:   "declared",
+      Source: "type User struct{}\ntype UserRef struct{}\ntype Post struct {\n  A *User `ref:\"a_id\"`\n}\n",
+      Expect: []string{"*User PtrToUserRef"},
+      Empty:  "isEmptyValue",
+    },
+    {
+      Name:   "declared constructor",
+      Source: "type User struct{}\nfunc NewUserRefId() {}\ntype Post struct {\n  A *User `ref:\"a_id\"`\n}\n",
+      Expect: []string{"*User PtrToUserRef"},
+      Empty:  "isEmptyValue",
+    },
+    {
+      Name:   "unresolvable",
+      Source: "type User struct{}\ntype UserRef struct{}\ntype PtrToUserRef struct{}\ntype Post struct {\n  A *User `ref:\"a_id\"`\n}\n",
+      Error:  `Cannot name the ref type for *User without a collision; pin a name with the "type=" ref tag option`,
+    },
+    {
+      Name:   "pinned",
+      Source: "type User struct{}\ntype UserRef struct{}\ntype PtrToUserRef struct{}\ntype Post struct {\n  A *User `ref:\"a_id,type=UserLink\"`\n  B *User `ref:\"b_id,type=UserLink\"`\n}\n",
+      Expect: []string{"*User UserLink"},
+      Empty:  "isEmptyValue",
+    },
+    {
+      Name:   "pinned and unpinned",
+      Source: "type User struct{}\ntype Post struct {\n  A *User `ref:\"a_id,type=UserLink\"`\n  B *User `ref:\"b_id\"`\n}\n",
+      Expect: []string{"*User UserLink", "*User UserRef"},
+      Empty:  "isEmptyValue",
+    },
+    {
+      Name:   "pin collides with declaration",
+      Source: "type User struct{}\ntype UserLink struct{}\ntype Post struct {\n  A *User `ref:\"a_id,type=UserLink\"`\n}\n",
+      Error:  "Pinned ref type name UserLink for *User collides with a declaration in the package",
+    },
+    {
+      Name:   "pins collide",
+      Source: "type User struct{}\ntype Org struct{}\ntype Post struct {\n  A *User `ref:\"a_id,type=Link\"`\n  B *Org `ref:\"b_id,type=Link\"`\n}\n",
+      Error:  "Pinned ref type name Link for *User collides with the ref type for *Org",
+    },
+    {
+      Name:   "empty value routine",
+      Source: "type User struct{}\nfunc isEmptyValue() {}\ntype Post struct {\n  A *User `ref:\"a_id\"`\n}\n",
+      Expect: []string{"*User UserRef"},
+      Empty:  "refIsEmptyValue",
+    },
+    {
+      Name:   "empty value routines",
+      Source: "type User struct{}\nfunc isEmptyValue() {}\nfunc refIsEmptyValue() {}\ntype Post struct {\n  A *User `ref:\"a_id\"`\n}\n",
+      Expect: []string{"*User UserRef"},
+      Empty:  "refIsEmptyValue2",
+    },
+  }
+  for _, e := range tests {
+    t.Run(e.Name, func(t *testing.T) {
+      names, empty, err := resolveTestNames(t, "package a\n\n"+ e.Source)
+      if e.Error != "" {
+        assert.EqualError(t, err, e.Error)
+        return
+      }
+      if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+        assert.Equal(t, e.Expect, names)
+        assert.Equal(t, e.Empty, empty)
+      }
+    })
+  }
+}
+
+func TestRenamedEmptyValue(t *testing.T) {
+  dir := writePackage(t, map[string]string{"a.go": "//go:build ignore\n\npackage a\n\nfunc isEmptyValue() bool { return true }\n\ntype User struct{}\n\ntype Post struct {\n  A *User `json:\"a,omitempty\" ref:\"a_id\"`\n}\n"})
+  applySettings(t, settings{})
+  err := procDir(dir, optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  data, err := os.ReadFile(filepath.Join(dir, pkgSrc + fileSuffix +".go"))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Contains(t, string(data), "func refIsEmptyValue(")
+    assert.True(t, strings.Count(string(data), "refIsEmptyValue(") > 1, "The renamed routine is not used")
+    assert.NotContains(t, string(data), "isEmptyValue(v")
+  }
+}
+
+func TestRenamedForAddedFile(t *testing.T) {
+  dir := writeModule(t, map[string]map[string]string{
+    "p": {"a.go": "//go:build ignore\n\npackage p\n\nimport \"encoding/json\"\n\ntype A struct {\n  X *json.RawMessage `json:\"x\" ref:\"x_id\"`\n}\n"},
+  })
+  applySettings(t, settings{})
+  
+  pkg := filepath.Join(dir, "p")
+  err := procDir(pkg, optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  data, err := os.ReadFile(filepath.Join(pkg, "a"+ fileSuffix +".go"))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Contains(t, string(data), "X *RawMessageRef")
+  }
+  
+  // a file added later takes the plain name for a type of its own, so the
+  // copy of the first, though up to date, refers to the qualified name now
+  err = os.WriteFile(filepath.Join(pkg, "b.go"), []byte("//go:build ignore\n\npackage p\n\ntype RawMessage struct {\n  Id string `json:\"id\"`\n}\n\ntype B struct {\n  Y *RawMessage `json:\"y\" ref:\"y_id\"`\n}\n"), 0644)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  err = procDir(pkg, optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  data, err = os.ReadFile(filepath.Join(pkg, "a"+ fileSuffix +".go"))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Contains(t, string(data), "X *JsonRawMessageRef")
+  }
+  
+  cmd := exec.Command("go", "vet", "./...")
+  cmd.Dir = dir
+  out, err := cmd.CombinedOutput()
+  assert.Nil(t, err, "The generated package doesn't build: %s", out)
+}
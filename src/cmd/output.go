@@ -0,0 +1,220 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "go/token"
+  "go/parser"
+  "path/filepath"
+  "golang.org/x/tools/go/packages"
+)
+
+/**
+ * A generated file, or a source we fix, waiting to be written
+ */
+type output struct {
+  Path      string      // where the file is written
+  Name      string      // the file it is in the package; different for overlays
+  Data      []byte
+  Mode      os.FileMode // its permissions, if not the usual 0644
+  Remove    bool        // the file is removed rather than written
+}
+
+/**
+ * The files generated for a package. They are held in memory until the
+ * whole package has been processed so that a failure doesn't leave some
+ * of them updated and some not.
+ */
+type outputSet []*output
+
+func (s *outputSet) Add(p, name string, data []byte) error {
+  return s.Append(&output{Path:p, Name:name, Data:data})
+}
+
+/**
+ * Add a file to the set. No two files may be written to the same path, since
+ * whichever came last would silently replace the other; that happens when a
+ * source file is named like a file we generate.
+ */
+func (s *outputSet) Append(o *output) error {
+  p, err := filepath.Abs(o.Path)
+  if err != nil {
+    return err
+  }
+  for _, e := range *s {
+    if q, err := filepath.Abs(e.Path); err == nil && q == p {
+      return fmt.Errorf("%v: more than one file would be generated here; rename the source file it's derived from", o.Path)
+    }
+  }
+  *s = append(*s, o)
+  return nil
+}
+
+/**
+ * Write every file in the set. Each is written to a temporary file beside
+ * its destination first; only once they have all been written are they
+ * renamed into place.
+ */
+func (s outputSet) Commit() error {
+  if DEBUG {
+    for _, e := range s {
+      if e.Remove {
+        fmt.Printf("// %v (removed)\n", e.Path)
+      }else{
+        fmt.Printf("// %v\n%s", e.Path, e.Data)
+      }
+    }
+    return nil
+  }
+  
+  tmps := make([]string, 0, len(s))
+  defer func() {
+    for _, e := range tmps {
+      if e != "" {
+        os.Remove(e) // anything left over was not committed
+      }
+    }
+  }()
+  
+  for _, e := range s {
+    if e.Remove {
+      tmps = append(tmps, "")
+      continue
+    }
+    t, err := writeTemp(e.Path, e.Data, e.Mode)
+    if err != nil {
+      return err
+    }
+    tmps = append(tmps, t)
+  }
+  
+  for i, e := range s {
+    var err error
+    if e.Remove {
+      err = os.Remove(e.Path)
+      if os.IsNotExist(err) {
+        err = nil
+      }
+    }else{
+      err = os.Rename(tmps[i], e.Path)
+    }
+    if err != nil {
+      return err
+    }
+    tmps[i] = ""
+  }
+  
+  return nil
+}
+
+/**
+ * Write data to a temporary file in the same directory as the provided path
+ * (so it can be renamed over it) and return the temporary file's path. The
+ * name starts with a dot so the go tool and our watcher ignore it.
+ */
+func writeTemp(p string, data []byte, mode os.FileMode) (string, error) {
+  if mode == 0 {
+    mode = 0644
+  }
+  err := os.MkdirAll(filepath.Dir(p), 0755)
+  if err != nil {
+    return "", err
+  }
+  
+  f, err := os.CreateTemp(filepath.Dir(p), "."+ filepath.Base(p) +".*")
+  if err != nil {
+    return "", err
+  }
+  _, err = f.Write(data)
+  if cerr := f.Close(); err == nil {
+    err = cerr
+  }
+  if err == nil {
+    err = os.Chmod(f.Name(), mode)
+  }
+  if err != nil {
+    os.Remove(f.Name())
+    return "", err
+  }
+  
+  return f.Name(), nil
+}
+
+/**
+ * Make sure generated source parses
+ */
+func checkSource(p string, data []byte) error {
+  _, err := parser.ParseFile(token.NewFileSet(), p, data, parser.AllErrors)
+  if err != nil {
+    return fmt.Errorf("%v: generated code is invalid: %v", p, err)
+  }
+  return nil
+}
+
+/**
+ * Type-check a package as it will be once its generated files are written.
+ * Generated files are provided to the go tool as an overlay, along with any
+ * existing overlay entries for the package that we didn't regenerate.
+ */
+func typeCheckPackage(dir string, outs outputSet) error {
+  abs, err := filepath.Abs(dir)
+  if err != nil {
+    return err
+  }
+  
+  files := make(map[string][]byte)
+  if overlayDir != "" {
+    o, err := currentOverlay()
+    if err != nil {
+      return err
+    }
+    for k, v := range o.Replace {
+      if filepath.Dir(k) != abs {
+        continue
+      }
+      data, err := os.ReadFile(v)
+      if err == nil {
+        files[k] = data
+      }
+    }
+  }
+  for _, e := range outs {
+    if e.Remove {
+      continue
+    }
+    p, err := filepath.Abs(e.Name)
+    if err != nil {
+      return err
+    }
+    files[p] = e.Data
+  }
+  
+  var flags []string
+  if constraintTag != "" {
+    flags = append(flags, "-tags="+ constraintTag)
+  }
+  
+  cnf := &packages.Config{
+    Mode:       packages.NeedName | packages.NeedFiles | packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo,
+    Dir:        abs,
+    BuildFlags: flags,
+    Overlay:    files,
+  }
+  pkgs, err := packages.Load(cnf, ".")
+  if err != nil {
+    return err
+  }
+  
+  var errs []packages.Error
+  for _, e := range pkgs {
+    errs = append(errs, e.Errors...)
+  }
+  if len(errs) > 0 {
+    if len(errs) > 1 {
+      return fmt.Errorf("%v: type check failed: %v (and %d more errors)", dir, errs[0], len(errs) - 1)
+    }
+    return fmt.Errorf("%v: type check failed: %v", dir, errs[0])
+  }
+  
+  return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+  "os"
+  "sort"
+  "bytes"
+  "path/filepath"
+  "encoding/json"
+)
+
+/**
+ * The overlay file, in the format expected by go build -overlay
+ */
+const overlayFile = "overlay.json"
+
+type overlayConfig struct {
+  Replace   map[string]string
+}
+
+/**
+ * Overlays we have touched in this run, keyed by their cache directory
+ */
+var overlays = make(map[string]*overlayConfig)
+
+/**
+ * Determine where the output for a source file goes in the overlay cache.
+ * Absolute source paths are mirrored beneath the cache directory so they
+ * can't collide.
+ */
+func overlayPath(src string) (string, error) {
+  abs, err := filepath.Abs(src)
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(overlayDir, abs), nil
+}
+
+/**
+ * Obtain the overlay for the current cache directory, loading whatever is
+ * already there so entries for other packages are preserved.
+ */
+func currentOverlay() (*overlayConfig, error) {
+  if o, ok := overlays[overlayDir]; ok {
+    return o, nil
+  }
+  
+  o := &overlayConfig{Replace:make(map[string]string)}
+  data, err := os.ReadFile(filepath.Join(overlayDir, overlayFile))
+  if err == nil {
+    err = json.Unmarshal(data, o)
+    if err != nil {
+      return nil, err
+    }
+    if o.Replace == nil {
+      o.Replace = make(map[string]string)
+    }
+  }else if !os.IsNotExist(err) {
+    return nil, err
+  }
+  
+  overlays[overlayDir] = o
+  return o, nil
+}
+
+/**
+ * Drop every entry for files in a package directory; they are added back as
+ * the package is processed, which takes care of files that no longer need
+ * to be rewritten.
+ */
+func overlayReset(dir string) error {
+  o, err := currentOverlay()
+  if err != nil {
+    return err
+  }
+  abs, err := filepath.Abs(dir)
+  if err != nil {
+    return err
+  }
+  for k := range o.Replace {
+    if filepath.Dir(k) == abs {
+      delete(o.Replace, k)
+    }
+  }
+  return nil
+}
+
+/**
+ * Replace a file in the package with its rewritten copy. The file being
+ * replaced doesn't have to exist, which is how the package file is added.
+ */
+func overlayReplace(src, dst string) error {
+  o, err := currentOverlay()
+  if err != nil {
+    return err
+  }
+  abs, err := filepath.Abs(src)
+  if err != nil {
+    return err
+  }
+  o.Replace[abs] = dst
+  return nil
+}
+
+/**
+ * Write every overlay we have touched
+ */
+func flushOverlays() error {
+  dirs := make([]string, 0, len(overlays))
+  for k := range overlays {
+    dirs = append(dirs, k)
+  }
+  sort.Strings(dirs)
+  
+  for _, e := range dirs {
+    data, err := json.MarshalIndent(overlays[e], "", "  ")
+    if err != nil {
+      return err
+    }
+    data = append(data, '\n')
+    
+    p := filepath.Join(e, overlayFile)
+    curr, err := os.ReadFile(p)
+    if err == nil && bytes.Equal(curr, data) {
+      continue // unchanged; don't disturb the build cache
+    }
+    
+    err = os.MkdirAll(e, 0755)
+    if err != nil {
+      return err
+    }
+    err = os.WriteFile(p, data, 0644)
+    if err != nil {
+      return err
+    }
+  }
+  
+  overlays = make(map[string]*overlayConfig)
+  return nil
+}
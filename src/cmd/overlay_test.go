@@ -0,0 +1,89 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "testing"
+  "path/filepath"
+  "encoding/json"
+  "github.com/stretchr/testify/assert"
+)
+
+func readOverlay(t *testing.T, dir string) map[string]string {
+  data, err := os.ReadFile(filepath.Join(dir, overlayFile))
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    t.FailNow()
+  }
+  o := overlayConfig{}
+  if !assert.Nil(t, json.Unmarshal(data, &o)) {
+    t.FailNow()
+  }
+  return o.Replace
+}
+
+func TestOverlay(t *testing.T) {
+  src := map[string]string{"a.go": cleanSource}
+  a, b := writePackage(t, src), writePackage(t, src)
+  cache := t.TempDir()
+  
+  // an entry some other run left for a package we don't touch
+  other := map[string]string{"/elsewhere/x.go": "/cache/elsewhere/x.go"}
+  data, err := json.Marshal(overlayConfig{Replace:other})
+  if assert.Nil(t, err) {
+    assert.Nil(t, os.WriteFile(filepath.Join(cache, overlayFile), data, 0644))
+  }
+  
+  applySettings(t, settings{Overlay:stringPtr(cache), OverlayPkg:boolPtr(true)})
+  for _, e := range []string{a, b} {
+    err = procDir(e, optionNone)
+    if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+      return
+    }
+  }
+  if !assert.Nil(t, flushOverlays()) {
+    return
+  }
+  
+  expect := map[string]string{"/elsewhere/x.go":"/cache/elsewhere/x.go"}
+  for _, e := range []string{a, b} {
+    for _, f := range []string{"a.go", pkgSrc + fileSuffix +".go"} {
+      expect[filepath.Join(e, f)] = filepath.Join(cache, e, f)
+    }
+  }
+  replace := readOverlay(t, cache)
+  assert.Equal(t, expect, replace)
+  for k, v := range replace {
+    if k == "/elsewhere/x.go" {
+      continue
+    }
+    gen, err := isFileGenerated(v)
+    assert.Nil(t, err, fmt.Sprintf("%v", err))
+    assert.True(t, gen, v)
+  }
+  
+  // nothing is written to the package directories
+  for _, e := range []string{a, b} {
+    entries, err := os.ReadDir(e)
+    if assert.Nil(t, err) {
+      assert.Len(t, entries, 1)
+    }
+  }
+  
+  // a re-run replaces the package's entries and keeps the rest
+  FORCE = true
+  err = procDir(a, optionNone)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) && assert.Nil(t, flushOverlays()) {
+    assert.Equal(t, expect, readOverlay(t, cache))
+  }
+  
+  // cleaning a package removes its copies and entries and keeps the rest
+  err = cleanDir(a)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) && assert.Nil(t, flushOverlays()) {
+    for _, f := range []string{"a.go", pkgSrc + fileSuffix +".go"} {
+      delete(expect, filepath.Join(a, f))
+      _, err := os.Stat(filepath.Join(cache, a, f))
+      assert.True(t, os.IsNotExist(err), f)
+    }
+    assert.Equal(t, expect, readOverlay(t, cache))
+  }
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "path"
+  "bytes"
+  "strings"
+  "go/token"
+  "go/format"
+)
+
+/**
+ * Generated proto files are named for their package with this suffix, and
+ * conversion functions are generated in this file, with the file suffix. It's
+ * named like the package file, so only a source named for it, whose copy
+ * would be written there, can collide with it.
+ */
+const (
+  protoSuffix     = ".proto"
+  protoSrc        = "pkg_proto"
+)
+
+/**
+ * Imports used by conversion functions: the package generated for the proto
+ * file by protoc-gen-go, and the well-known type times are converted to
+ */
+const (
+  protoPkgAlias   = "ref_pb"
+  timestampAlias  = "ref_timestamppb"
+  timestampPath   = "google.golang.org/protobuf/types/known/timestamppb"
+  timestampProto  = "google/protobuf/timestamp.proto"
+  timestampType   = "google.protobuf.Timestamp"
+)
+
+/**
+ * The scalar type a Go basic type is converted to, and the Go type
+ * protoc-gen-go gives it
+ */
+var protoScalars = map[string][2]string{
+  "bool":     {"bool", "bool"},
+  "string":   {"string", "string"},
+  "int":      {"int64", "int64"},
+  "int8":     {"int32", "int32"},
+  "int16":    {"int32", "int32"},
+  "int32":    {"int32", "int32"},
+  "rune":     {"int32", "int32"},
+  "int64":    {"int64", "int64"},
+  "uint":     {"uint64", "uint64"},
+  "uint8":    {"uint32", "uint32"},
+  "byte":     {"uint32", "uint32"},
+  "uint16":   {"uint32", "uint32"},
+  "uint32":   {"uint32", "uint32"},
+  "uint64":   {"uint64", "uint64"},
+  "uintptr":  {"uint64", "uint64"},
+  "float32":  {"float", "float32"},
+  "float64":  {"double", "float64"},
+}
+
+/**
+ * Produce the Go name protoc-gen-go gives a proto name
+ */
+func protoGoName(s string) string {
+  lower := func(c byte) bool { return c >= 'a' && c <= 'z' }
+  var b []byte
+  for i := 0; i < len(s); i++ {
+    c := s[i]
+    switch {
+      case c == '_' && i == 0:
+        b = append(b, 'X')
+      case c == '_' && i+1 < len(s) && lower(s[i+1]):
+        // dropped before a lowercase letter, which is capitalized
+      case c >= '0' && c <= '9':
+        b = append(b, c)
+      default:
+        if lower(c) {
+          c -= 'a' - 'A'
+        }
+        b = append(b, c)
+        for ; i+1 < len(s) && lower(s[i+1]); i++ {
+          b = append(b, s[i+1])
+        }
+    }
+  }
+  return string(b)
+}
+
+/**
+ * Produce a proto field name for a JSON key
+ */
+func protoFieldName(k string) string {
+  b := []byte(k)
+  for i, c := range b {
+    if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+      b[i] = '_'
+    }
+  }
+  if len(b) == 0 || b[0] >= '0' && b[0] <= '9' {
+    b = append([]byte("f_"), b...)
+  }
+  return string(b)
+}
+
+/**
+ * How a value is converted: its proto type, the Go type protoc-gen-go gives
+ * it, and expressions that convert it each way. A struct value is converted
+ * from its message by a statement instead, since the conversion returns a
+ * pointer; Msg names its message.
+ */
+type protoConv struct {
+  Type      string
+  PbGo      string
+  To        func(string) string
+  From      func(string) string
+  Msg       string
+}
+
+/**
+ * The proto file and conversion functions for a wire model
+ */
+type protoGen struct {
+  m         *wireModel
+  time      bool
+  refs      bool
+}
+
+/**
+ * The conversion of a scalar, which may be a named type declared in the
+ * package as one
+ */
+func (g *protoGen) scalar(t *wireType) (*protoConv, bool) {
+  if t.Nullable {
+    return nil, false
+  }
+  base := t
+  if t.Kind == wireNamed {
+    base = g.m.Types[t.Name]
+    if base == nil || base.Nullable {
+      return nil, false
+    }
+  }
+  s, ok := protoScalars[base.Go]
+  if !ok {
+    return nil, false
+  }
+  return &protoConv{
+    Type: s[0],
+    PbGo: s[1],
+    To:   func(x string) string { return s[1] +"("+ x +")" },
+    From: func(x string) string { return t.Go +"("+ x +")" },
+  }, true
+}
+
+/**
+ * Determine if a type refers to a struct declared in the package, which is
+ * converted to its message. Structs declared in other packages have none.
+ */
+func (g *protoGen) isMessage(t *wireType) bool {
+  d := g.m.Types[t.Name]
+  return t.Kind == wireNamed && d != nil && d.Kind == wireObject && !d.Nullable && !strings.Contains(t.Name, ".")
+}
+
+/**
+ * The conversion of a value that is not nullable, or a nullable struct,
+ * which converts to and from a message as a pointer
+ */
+func (g *protoGen) value(t *wireType) (*protoConv, string) {
+  if c, ok := g.scalar(t); ok {
+    return c, ""
+  }
+  if t.Kind == wireNamed {
+    if !g.isMessage(t) {
+      return nil, "its type is neither a struct nor a scalar: "+ t.Go
+    }
+    n := protoGoName(t.Name)
+    c := &protoConv{Type:t.Name, PbGo:"*"+ protoPkgAlias +"."+ n}
+    if t.Nullable {
+      c.To = func(x string) string { return t.Name +"ToProto("+ x +")" }
+      c.From = func(x string) string { return t.Name +"FromProto("+ x +")" }
+    }else{
+      c.To = func(x string) string { return t.Name +"ToProto(&"+ x +")" }
+      c.Msg = t.Name
+    }
+    return c, ""
+  }
+  if t.Nullable {
+    return nil, "it is nullable: "+ t.Go
+  }
+  switch t.Kind {
+    case wireBytes:
+      return &protoConv{
+        Type: "bytes",
+        PbGo: "[]byte",
+        To:   func(x string) string { return x },
+        From: func(x string) string { return x },
+      }, ""
+    case wireTime:
+      g.time = true
+      return &protoConv{
+        Type: timestampType,
+        PbGo: "*"+ timestampAlias +".Timestamp",
+        To:   func(x string) string { return timestampAlias +".New("+ x +")" },
+        From: func(x string) string { return x +".AsTime()" },
+      }, ""
+  }
+  return nil, "its type has no protobuf equivalent: "+ t.Go
+}
+
+/**
+ * A statement that converts a value from its message, which is a struct or
+ * has an expression that converts it
+ */
+func (c *protoConv) FromStmt(dst, src string) string {
+  if c.From != nil {
+    return dst +" = "+ c.From(src) +"\n"
+  }
+  return fmt.Sprintf("if p := %sFromProto(%s); p != nil {\n  %s = *p\n}\n", c.Msg, src, dst)
+}
+
+/**
+ * Produce the body of the message for an object and the statements that
+ * convert to and from it, between the Go value src and the message dst. Fields
+ * that cannot be converted are noted in the message and left out.
+ */
+func (g *protoGen) message(t *wireType, pb string, depth int) (string, string, string, error) {
+  ind := strings.Repeat("  ", depth + 1)
+  var proto, nested, to, from string
+  n := 0
+  field := func(typ, name string) {
+    n++
+    proto += fmt.Sprintf("%s%s %s = %d;\n", ind, typ, name, n)
+  }
+  
+  for _, f := range t.Fields {
+    name := protoFieldName(f.Key)
+    pgo := protoGoName(name)
+    src, dst := "v."+ f.Name, "m."+ pgo
+    skip := func(why string) {
+      proto += fmt.Sprintf("%s// %s is not converted: %s\n", ind, f.Name, why)
+    }
+    if f.Promoted {
+      skip("it is promoted from an embedded struct")
+      continue
+    }
+    
+    switch {
+      case f.Ref:
+        if strings.Contains(f.RefType, ".") {
+          skip("its ref type is shared by another package")
+          continue
+        }
+        id, ok := g.scalar(f.Id)
+        if !ok {
+          return "", "", "", fmt.Errorf("Ref ids must be a scalar type to be converted to protobuf: %v", f.Id.Go)
+        }
+        // the value is a message, or a slice wrapped in one, since a oneof
+        // can't have a repeated field
+        value := f.Type.Name
+        var toPre, toVal, fromPre, fromVal string
+        switch {
+          case g.isMessage(f.Type):
+            toVal = f.Type.Name +"ToProto("+ src +".Value)"
+            fromVal = f.Type.Name +"FromProto(x."+ pgo +")"
+          case f.Type.Kind == wireArray && strings.HasPrefix(f.Type.Go, "[]"):
+            c, why := g.value(f.Type.Elem)
+            if why != "" {
+              return "", "", "", fmt.Errorf("Ref field %v can't be converted to protobuf; its elements can't be: %v", f.Name, why)
+            }
+            value = protoGoName(name) +"Values"
+            nested += fmt.Sprintf("%smessage %s {\n%s  repeated %s values = 1;\n%s}\n", ind, value, ind, c.Type, ind)
+            toPre = fmt.Sprintf("x := &%s.%s_%s{}\nfor _, e := range %s.Value {\n  x.Values = append(x.Values, %s)\n}\n", protoPkgAlias, pb, value, src, c.To("e"))
+            toVal = "x"
+            fromPre = fmt.Sprintf("e := make(%s, 0, len(x.%s.GetValues()))\nfor _, y := range x.%s.GetValues() {\n", f.Type.Go, pgo, pgo)
+            if c.From != nil {
+              fromPre += fmt.Sprintf("  e = append(e, %s)\n}\n", c.From("y"))
+            }else{
+              fromPre += fmt.Sprintf("  var z %s\n%s  e = append(e, z)\n}\n", f.Type.Elem.Go, indent(1, c.FromStmt("z", "y")))
+            }
+            fromVal = "e"
+          default:
+            return "", "", "", fmt.Errorf("Ref field %v can't be converted to protobuf; only refs to structs in the package, or slices of them, can be: %v", f.Name, f.Type.Go)
+        }
+        g.refs = true
+        idName := protoFieldName(f.IdKey)
+        oneof, idGo := protoGoName(name +"_ref"), protoGoName(idName)
+        proto += fmt.Sprintf("%soneof %s_ref {\n", ind, name)
+        n++
+        proto += fmt.Sprintf("%s  %s %s = %d;\n", ind, id.Type, idName, n)
+        n++
+        proto += fmt.Sprintf("%s  %s %s = %d;\n", ind, value, name, n)
+        proto += ind +"}\n"
+        to += fmt.Sprintf(`if %[1]s != nil {
+  if %[1]s.HasValue() {
+%[6]sm.%[2]s = &%[3]s.%[4]s_%[5]s{%[5]s:%[7]s}
+  }else{
+    m.%[2]s = &%[3]s.%[4]s_%[8]s{%[8]s:%[9]s}
+  }
+}
+`,      src, oneof, protoPkgAlias, pb, pgo, indent(2, toPre), toVal, idGo, id.To(src +".Id"))
+        from += fmt.Sprintf(`switch x := m.%[1]s.(type) {
+  case *%[2]s.%[3]s_%[4]s:
+    %[5]s = New%[6]sId(%[7]s)
+  case *%[2]s.%[3]s_%[8]s:
+%[9]s%[5]s = New%[6]s(%[10]s)
+}
+`,      oneof, protoPkgAlias, pb, idGo, src, f.RefType, id.From("x."+ idGo), pgo, indent(2, fromPre), fromVal)
+      
+      case f.Type.Kind == wireObject:
+        if f.Type.Nullable {
+          skip("it is a pointer to an anonymous struct")
+          continue
+        }
+        msg := protoGoName(f.Name)
+        body, mto, mfrom, err := g.message(f.Type, pb +"_"+ msg, depth + 1)
+        if err != nil {
+          return "", "", "", err
+        }
+        nested += fmt.Sprintf("%smessage %s {\n%s%s}\n", ind, msg, body, ind)
+        field(msg, name)
+        to += fmt.Sprintf("{\n  v := &%s\n  m.%s = &%s.%s_%s{}\n  m := m.%s\n%s}\n", src, pgo, protoPkgAlias, pb, msg, pgo, indent(1, mto))
+        from += fmt.Sprintf("if m := m.%s; m != nil {\n  v := &%s\n%s}\n", pgo, src, indent(1, mfrom))
+        
+      case f.Type.Kind == wireArray:
+        if !strings.HasPrefix(f.Type.Go, "[]") {
+          skip("it is an array, which has a fixed length")
+          continue
+        }
+        c, why := g.value(f.Type.Elem)
+        if why != "" {
+          skip("its elements can't be converted; "+ why)
+          continue
+        }
+        field("repeated "+ c.Type, name)
+        to += fmt.Sprintf("for _, e := range %s {\n  %s = append(%s, %s)\n}\n", src, dst, dst, c.To("e"))
+        if c.From != nil {
+          from += fmt.Sprintf("for _, e := range %s {\n  %s = append(%s, %s)\n}\n", dst, src, src, c.From("e"))
+        }else{
+          from += fmt.Sprintf("for _, e := range %s {\n  var x %s\n%s  %s = append(%s, x)\n}\n", dst, f.Type.Elem.Go, indent(1, c.FromStmt("x", "e")), src, src)
+        }
+        
+      case f.Type.Kind == wireMap:
+        k, ok := g.scalar(f.Type.Key)
+        if !ok || k.Type == "float" || k.Type == "double" {
+          skip("its keys are not strings, integers or bools")
+          continue
+        }
+        c, why := g.value(f.Type.Elem)
+        if why == "" && strings.Contains(f.Type.Go, ".") {
+          why = "its type is declared in another package: "+ f.Type.Go
+        }
+        if why != "" {
+          skip("its values can't be converted; "+ why)
+          continue
+        }
+        field(fmt.Sprintf("map<%s, %s>", k.Type, c.Type), name)
+        to += fmt.Sprintf("if %s != nil {\n  %s = make(map[%s]%s, len(%s))\n  for k, e := range %s {\n    %s[%s] = %s\n  }\n}\n", src, dst, k.PbGo, c.PbGo, src, src, dst, k.To("k"), c.To("e"))
+        if c.From != nil {
+          from += fmt.Sprintf("if %s != nil {\n  %s = make(%s, len(%s))\n  for k, e := range %s {\n    %s[%s] = %s\n  }\n}\n", dst, src, f.Type.Go, dst, dst, src, k.From("k"), c.From("e"))
+        }else{
+          from += fmt.Sprintf("if %s != nil {\n  %s = make(%s, len(%s))\n  for k, e := range %s {\n    var x %s\n%s    %s[%s] = x\n  }\n}\n", dst, src, f.Type.Go, dst, dst, f.Type.Elem.Go, indent(2, c.FromStmt("x", "e")), src, k.From("k"))
+        }
+        
+      case f.Type.Nullable && !g.isMessage(f.Type):
+        base := *f.Type
+        base.Nullable, base.Go = false, strings.TrimPrefix(f.Type.Go, "*")
+        c, why := g.value(&base)
+        if why == "" && strings.HasPrefix(base.Go, "*") {
+          why = "it is a pointer to a pointer"
+        }
+        if why != "" {
+          skip(why)
+          continue
+        }
+        if c.Type == timestampType {
+          // a message field is already optional
+          field(c.Type, name)
+          to += fmt.Sprintf("if %s != nil {\n  %s = %s\n}\n", src, dst, c.To("*"+ src))
+          from += fmt.Sprintf("if %s != nil {\n  x := %s\n  %s = &x\n}\n", dst, c.From(dst), src)
+        }else{
+          field("optional "+ c.Type, name)
+          to += fmt.Sprintf("if %s != nil {\n  x := %s\n  %s = &x\n}\n", src, c.To("*"+ src), dst)
+          from += fmt.Sprintf("if %s != nil {\n  x := %s\n  %s = &x\n}\n", dst, c.From("*"+ dst), src)
+        }
+        
+      default:
+        c, why := g.value(f.Type)
+        if why != "" {
+          skip(why)
+          continue
+        }
+        field(c.Type, name)
+        to += dst +" = "+ c.To(src) +"\n"
+        from += c.FromStmt(src, dst)
+        
+    }
+  }
+  
+  return nested + proto, to, from, nil
+}
+
+/**
+ * Produce the proto file for a wire model, in a proto package, and the Go
+ * conversion functions between each struct and the message protoc-gen-go
+ * generates for it in a Go package
+ */
+func (g *protoGen) Generate(pkg, goPkg string) (string, string, error) {
+  var msgs, conv string
+  for _, e := range g.m.Names {
+    t := g.m.Types[e]
+    if !g.isMessage(&wireType{Kind:wireNamed, Name:e}) {
+      continue
+    }
+    pb := protoGoName(e)
+    g.refs = false
+    body, to, from, err := g.message(t, pb, 0)
+    if err != nil {
+      return "", "", err
+    }
+    docTo, docFrom := "", ""
+    if g.refs {
+      docTo = "\n// A ref is set to its value if it has one, and otherwise to its id."
+      docFrom = "\n// A ref is made from whichever of its id or value is set."
+    }
+    msgs += fmt.Sprintf("\nmessage %s {\n%s}\n", e, body)
+    conv += fmt.Sprintf(`
+// %[1]sToProto converts %[1]s to its protobuf message.%[6]s
+func %[1]sToProto(v *%[1]s) *%[2]s.%[3]s {
+  if v == nil {
+    return nil
+  }
+  m := &%[2]s.%[3]s{}
+%[4]s  return m
+}
+
+// %[1]sFromProto converts a protobuf message to %[1]s.%[7]s
+func %[1]sFromProto(m *%[2]s.%[3]s) *%[1]s {
+  if m == nil {
+    return nil
+  }
+  v := &%[1]s{}
+%[5]s  return v
+}
+`,  e, protoPkgAlias, pb, indent(1, to), indent(1, from), docTo, docFrom)
+  }
+  
+  var proto bytes.Buffer
+  fmt.Fprintf(&proto, "// %s. Changes will be overwritten.\nsyntax = \"proto3\";\n\npackage %s;\n", generatedHeader, pkg)
+  if g.time {
+    fmt.Fprintf(&proto, "\nimport %q;\n", timestampProto)
+  }
+  fmt.Fprintf(&proto, "\noption go_package = %q;\n", goPkg)
+  proto.WriteString(msgs)
+  
+  imports := fmt.Sprintf("  %s %q\n", protoPkgAlias, goPkg)
+  if g.time {
+    imports += fmt.Sprintf("  %s %q\n", timestampAlias, timestampPath)
+  }
+  return proto.String(), "import (\n"+ imports +")\n"+ conv, nil
+}
+
+/**
+ * Write a proto file for the structs with ref fields in the packages in a
+ * directory, and the types they refer to, to the directory or another one,
+ * along with functions in the package that convert between them and the
+ * messages protoc-gen-go generates in another
+ */
+func writeProto(dir, out, pkg, goPkg string, opts options) error {
+  if goPkg == "" {
+    return fmt.Errorf("The import path of the package protoc-gen-go generates is required: -go-package")
+  }
+  if out == "" {
+    out = dir
+  }
+  return inspectDir(dir, opts, func(cxt *context, fset *token.FileSet) error {
+    m, err := buildWireModel(cxt)
+    if err != nil {
+      return err
+    }
+    if len(m.Names) == 0 {
+      return nil
+    }
+    for _, e := range m.Names {
+      for _, n := range []string{e +"ToProto", e +"FromProto"} {
+        if _, ok := cxt.Decls[n]; ok && m.Types[e].Kind == wireObject {
+          return fmt.Errorf("Conversion function %v collides with a declaration in the package", n)
+        }
+      }
+    }
+    
+    name := pkg
+    if name == "" {
+      name = cxt.Package
+    }
+    g := &protoGen{m:m}
+    proto, conv, err := g.Generate(name, goPkg)
+    if err != nil {
+      return err
+    }
+    
+    // don't write over a source's copy or a file that wasn't generated
+    outconv := path.Join(dir, protoSrc + fileSuffix +".go")
+    if _, err := os.Stat(path.Join(dir, protoSrc +".go")); err == nil {
+      return fmt.Errorf("%v: the copy of %v.go would be generated here too; rename it", outconv, protoSrc)
+    }
+    if ok, err := isFileGenerated(outconv); err == nil && !ok {
+      return fmt.Errorf("%v: file exists but was not generated; it would be overwritten with conversion functions", outconv)
+    }else if err != nil && !os.IsNotExist(err) {
+      return err
+    }
+    
+    gen := &bytes.Buffer{}
+    writeBuildLine(gen)
+    fmt.Fprintf(gen, "// %s. Changes will be overwritten.\n// %v\npackage %v\n\n%s", generatedHeader, outconv, cxt.Package, conv)
+    err = checkSource(outconv, gen.Bytes())
+    if err != nil {
+      return err
+    }
+    data, err := format.Source(gen.Bytes())
+    if err != nil {
+      return err
+    }
+    
+    // the conversion functions are useless without the messages they refer
+    // to, so neither is written unless both can be
+    var outs outputSet
+    err = outs.Add(path.Join(out, m.Package + protoSuffix), m.Package + protoSuffix, []byte(proto))
+    if err != nil {
+      return err
+    }
+    err = outs.Add(outconv, outconv, data)
+    if err != nil {
+      return err
+    }
+    return outs.Commit()
+  })
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestProtoGoName(t *testing.T) {
+  tests := map[string]string{
+    "author_id":    "AuthorId",
+    "author_ref":   "AuthorRef",
+    "_meta":        "XMeta",
+    "x2_y":         "X2Y",
+    "a_B":          "A_B",
+    "Meta":         "Meta",
+  }
+  for k, v := range tests {
+    assert.Equal(t, v, protoGoName(k), k)
+  }
+}
+
+func TestWriteProto(t *testing.T) {
+  dir := t.TempDir()
+  err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(`package a
+  
+import "time"
+
+type Status int8
+
+type User struct {
+  Id      string            `+"`json:\"id\"`"+`
+  Status  *Status           `+"`json:\"status\"`"+`
+  Seen    time.Time         `+"`json:\"seen\"`"+`
+  Labels  map[string]string `+"`json:\"labels,omitempty\"`"+`
+}
+
+type Post struct {
+  Title   string            `+"`json:\"title\"`"+`
+  Author  *User             `+"`json:\"author\" ref:\"author_id\"`"+`
+  Editors []*User           `+"`json:\"editors\" ref:\"editor_ids,value\"`"+`
+  Meta    struct {
+    Owner *User             `+"`json:\"owner\" ref:\"owner_id\"`"+`
+  }                         `+"`json:\"meta\"`"+`
+}
+`), 0644)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  out := t.TempDir()
+  err = writeProto(dir, out, "", "example.com/a/pb", optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  data, err := os.ReadFile(filepath.Join(out, "a"+ protoSuffix))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, `// This file was generated by Go-Ref. Changes will be overwritten.
+syntax = "proto3";
+
+package a;
+
+import "google/protobuf/timestamp.proto";
+
+option go_package = "example.com/a/pb";
+
+message Post {
+  message EditorsValues {
+    repeated User values = 1;
+  }
+  message Meta {
+    oneof owner_ref {
+      string owner_id = 1;
+      User owner = 2;
+    }
+  }
+  string title = 1;
+  oneof author_ref {
+    string author_id = 2;
+    User author = 3;
+  }
+  oneof editors_ref {
+    string editor_ids = 4;
+    EditorsValues editors = 5;
+  }
+  Meta meta = 6;
+}
+
+message User {
+  string id = 1;
+  optional int32 status = 2;
+  google.protobuf.Timestamp seen = 3;
+  map<string, string> labels = 4;
+}
+`, string(data))
+  }
+  
+  data, err = os.ReadFile(filepath.Join(dir, protoSrc + fileSuffix +".go"))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Contains(t, string(data), "m.AuthorRef = &ref_pb.Post_Author{Author: UserToProto(v.Author.Value)}")
+    assert.Contains(t, string(data), "m.OwnerRef = &ref_pb.Post_Meta_OwnerId{OwnerId: string(v.Owner.Id)}")
+    assert.Contains(t, string(data), "v.Author = NewUserRefId(string(x.AuthorId))")
+    assert.Contains(t, string(data), "x := Status(*m.Status)")
+    assert.Contains(t, string(data), "m.EditorsRef = &ref_pb.Post_Editors{Editors: x}")
+    assert.Contains(t, string(data), "v.Editors = NewArrayOfPtrToUserRef(e)")
+  }
+  
+  err = writeProto(dir, out, "", "", optionNone)
+  assert.NotNil(t, err)
+}
+
+func TestWriteProtoCollides(t *testing.T) {
+  src := "package a\n\ntype User struct {\n  Id string `json:\"id\"`\n}\n\ntype Post struct {\n  Author *User `json:\"author\" ref:\"author_id\"`\n}\n"
+  
+  // a file that wasn't generated is left alone
+  dir := t.TempDir()
+  conv := filepath.Join(dir, protoSrc + fileSuffix +".go")
+  assert.Nil(t, os.WriteFile(filepath.Join(dir, "a.go"), []byte(src), 0644))
+  assert.Nil(t, os.WriteFile(conv, []byte("package a\n"), 0644))
+  err := writeProto(dir, t.TempDir(), "", "example.com/a/pb", optionNone)
+  assert.EqualError(t, err, conv +": file exists but was not generated; it would be overwritten with conversion functions")
+  data, err := os.ReadFile(conv)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, "package a\n", string(data))
+  }
+  
+  // nor is a source's copy
+  dir = t.TempDir()
+  conv = filepath.Join(dir, protoSrc + fileSuffix +".go")
+  assert.Nil(t, os.WriteFile(filepath.Join(dir, protoSrc +".go"), []byte(src), 0644))
+  err = writeProto(dir, t.TempDir(), "", "example.com/a/pb", optionNone)
+  assert.EqualError(t, err, conv +": the copy of "+ protoSrc +".go would be generated here too; rename it")
+}
+
+func TestWriteProtoUnsupportedRef(t *testing.T) {
+  dir := t.TempDir()
+  err := os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n\ntype User struct {\n  Id string `json:\"id\"`\n}\n\ntype Post struct {\n  Editors map[string]*User `json:\"editors\" ref:\"editor_ids\"`\n}\n"), 0644)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  err = writeProto(dir, t.TempDir(), "", "example.com/a/pb", optionNone)
+  assert.EqualError(t, err, "Ref field Editors can't be converted to protobuf; only refs to structs in the package, or slices of them, can be: map[string]*User")
+}
@@ -4,6 +4,8 @@ import (
   "os"
   "io"
   "fmt"
+  "sort"
+  "bytes"
   "flag"
   "path"
   "strings"
@@ -11,7 +13,13 @@ import (
   "reflect"
   "go/ast"
   "go/token"
+  "go/format"
   "go/parser"
+  "go/printer"
+  "go/scanner"
+  "go/build/constraint"
+  "reftag"
+  "golang.org/x/tools/go/ast/astutil"
 )
 
 var (
@@ -62,14 +70,38 @@ func (s identSet) Add(id *ident) {
   s[id.Name] = id
 }
 
+/**
+ * Obtain the idents in the set, ordered by name
+ */
+func (s identSet) Sorted() []*ident {
+  ids := make([]*ident, 0, len(s))
+  for _, e := range s {
+    ids = append(ids, e)
+  }
+  sort.Slice(ids, func(i, j int) bool {
+    return ids[i].Name < ids[j].Name
+  })
+  return ids
+}
+
 /**
  * Reference type suffix
  */
 const (
   refSuffix     = "Ref"
   idSuffix      = "Id"
+  loaderSuffix  = "Loader"
+  columnSuffix  = "Column"
+  resolverSuffix = "Resolver"
+  httpResolverSuffix = "HTTPResolver"
+  cacheSuffix   = "Cache"
 )
 
+/**
+ * Generated files are identified by this header
+ */
+const generatedHeader = "This file was generated by Go-Ref"
+
 /**
  * Macros
  */
@@ -90,6 +122,33 @@ var (
   extraImports importSet
 )
 
+var (
+  constraintTag   = ""
+  fixConstraints  = false
+)
+
+var (
+  overlayDir      = ""
+  overlayPkg      = false
+)
+
+var (
+  typeCheck       = false
+)
+
+var (
+  sharedRefs      = false
+  sharedPkg       = ""
+)
+
+var (
+  lazyRefs        = false
+  cacheRefs       = false
+  refRegistry     = false
+  sqlTables       map[string]string
+  httpURLs        map[string]string
+)
+
 /**
  * Options
  */
@@ -111,13 +170,35 @@ type source struct {
  */
 type context struct {
   Package   string
+  Dir       string
+  ImportPath string
   Options   options
   Imports   importSet
   Deps      importSet
   Types     typeSet
-  Generate  identSet
+  Decls     nameSet
+  Methods   nameSet
+  Generate  refSet
   Marshal   identSet
   Lookup    map[string]*ident
+  Tables    map[string]string
+  URLs      map[string]string
+  IsEmpty   string
+  Copies    []*sourceCopy
+  Shared    map[string]*sharedFile
+  Loaded    map[string]*context
+  Output    outputSet
+}
+
+/**
+ * A rewritten source, held until the names of the types it refers to are
+ * resolved
+ */
+type sourceCopy struct {
+  Source      string
+  Dest        string
+  File        *ast.File
+  Constraint  constraint.Expr
 }
 
 /**
@@ -127,14 +208,46 @@ func newContext(pkg string, extra importSet, opts options) *context {
   if extra == nil {
     extra = make(importSet)
   }
-  return &context{pkg, opts, make(importSet), extra, make(typeSet), make(identSet), make(identSet), make(map[string]*ident)}
+  return &context{
+    Package:  pkg,
+    Options:  opts,
+    Imports:  make(importSet),
+    Deps:     extra,
+    Types:    make(typeSet),
+    Decls:    make(nameSet),
+    Methods:  make(nameSet),
+    Generate: make(refSet),
+    Marshal:  make(identSet),
+    Lookup:   make(map[string]*ident),
+    Tables:   make(map[string]string),
+    URLs:     make(map[string]string),
+    IsEmpty:  isEmptyFunc,
+    Shared:   make(map[string]*sharedFile),
+    Loaded:   make(map[string]*context),
+  }
 }
 
+/**
+ * Commands
+ */
+const (
+  cmdGenerate   = ""
+  cmdConfig     = "config"
+  cmdClean      = "clean"
+  cmdList       = "list"
+  cmdGraph      = "graph"
+  cmdSchema     = "schema"
+  cmdTypeScript = "typescript"
+  cmdProto      = "proto"
+)
+
 /**
  * You know what it does
  */
 func main() {
   var imports flagList
+  var tables flagList
+  var urls flagList
   
   if x := strings.LastIndex(os.Args[0], "/"); x > -1 {
     CMD = os.Args[0][x+1:]
@@ -142,35 +255,53 @@ func main() {
     CMD = os.Args[0]
   }
   
+  sub, argv := cmdGenerate, os.Args[1:]
+  if len(argv) > 0 {
+    switch argv[0] {
+      case cmdConfig, cmdClean, cmdList, cmdGraph, cmdSchema, cmdTypeScript, cmdProto:
+        sub, argv = argv[0], argv[1:]
+    }
+  }
+  
   cmdline         := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-  fIdent          := cmdline.String   ("ident",           "string",   "The type to use for generated identifiers.")
-  fBuildTag       := cmdline.String   ("build-tag",       "",         "Specify a Go build tag to be emitted in generated files.")
-  fFileSuffix     := cmdline.String   ("file-suffix",     "_ref",     "Specify the suffix to append to generated filenames.")
-  fStripComments  := cmdline.Bool     ("strip-comments",  true,       "Strip out build tags (and anything else in leading/doc comments).")
-  fForce          := cmdline.Bool     ("force",           false,      "Generate all files, including those which are not out-of-date.")
-  fDebug          := cmdline.Bool     ("debug",           false,      "Enable debugging mode.")
-  fTrace          := cmdline.Bool     ("trace",           false,      "Trace out (un)marshaled data.")
-  fVerbose        := cmdline.Bool     ("verbose",         false,      "Be more verbose.")
+  cmdline.String   ("ident",           "string",   "The type to use for generated identifiers.")
+  cmdline.String   ("build-tag",       "",         "Specify a Go build tag to be emitted in generated files.")
+  cmdline.String   ("file-suffix",     "_ref",     "Specify the suffix to append to generated filenames.")
+  cmdline.Bool     ("strip-comments",  true,       "Strip out build constraints and goref directives; doc comments are kept.")
+  cmdline.Bool     ("force",           false,      "Generate all files, including those which are not out-of-date.")
+  cmdline.Bool     ("debug",           false,      "Enable debugging mode.")
+  cmdline.Bool     ("trace",           false,      "Trace out (un)marshaled data.")
+  cmdline.Bool     ("verbose",         false,      "Be more verbose.")
+  cmdline.String   ("constraint",      "",         "Manage build constraints: generated files require this tag and sources are excluded by it.")
+  cmdline.Bool     ("fix-constraints", false,      "Insert the build constraint needed to exclude sources from builds of their generated copies.")
+  cmdline.String   ("overlay",         "",         "Write rewritten sources to this cache directory, with an overlay.json for go build -overlay.")
+  cmdline.Bool     ("overlay-pkg",     false,      "Also write the package file to the overlay instead of the package directory.")
+  cmdline.Bool     ("typecheck",       false,      "Type-check each package with its generated code before writing anything.")
+  cmdline.Bool     ("shared-refs",     false,      "Generate ref types once, in the package that declares the referenced type, and share them.")
+  cmdline.String   ("shared-pkg",      "",         "Generate shared ref types in this package (an import path) instead.")
+  cmdline.Bool     ("lazy",            false,      "Generate ref types that can carry a loader to fetch their values on first use.")
+  cmdline.Bool     ("cache",           false,      "Generate caches with expiry and de-duplicated loads to put in front of loaders; implies -lazy.")
+  cmdline.Bool     ("registry",        false,      "Generate a registry that describes the ref fields of each struct in a package.")
+  fWatch          := cmdline.Bool     ("watch",           false,      "Watch packages and regenerate them when their sources change.")
+  fJSON           := cmdline.Bool     ("json",            false,      "Produce JSON output (list).")
+  fFormat         := cmdline.String   ("format",          graphDOT,   "Produce graph output in this format: dot, mermaid or json (graph).")
+  fCross          := cmdline.Bool     ("cross-package",   true,       "Include references to types in other packages (graph).")
+  fOut            := cmdline.String   ("out",             "",         "Write files to this directory instead of the package directory (schema, typescript, proto).")
+  fProtoPkg       := cmdline.String   ("proto-package",   "",         "The package of generated protobuf messages; defaults to the Go package name (proto).")
+  fGoPkg          := cmdline.String   ("go-package",      "",         "The import path of the package protoc-gen-go generates messages in (proto).")
   cmdline.Var      (&imports,          "import",                      "Consider the provided package for import.")
-  cmdline.Parse(os.Args[1:])
-  
-  DEBUG           = *fDebug
-  TRACE           = *fTrace
-  VERBOSE         = *fVerbose
-  FORCE           = *fForce
-  idType          = *fIdent
-  buildTag        = *fBuildTag
-  fileSuffix      = *fFileSuffix
-  stripComments   = *fStripComments
+  cmdline.Var      (&tables,           "sql-table",                   "Generate a SQL resolver for a referenced type, loading it from a table: <type>=<table>.")
+  cmdline.Var      (&urls,             "http-url",                    "Generate an HTTP resolver for a referenced type, fetching it from a URL template: <type>=<url>.")
+  cmdline.Parse(argv)
   
-  if len(imports) > 0 {
-    extraImports = make(importSet)
-    for _, e := range imports {
-      extraImports.Add(&ast.ImportSpec{Path:&ast.BasicLit{Kind:token.STRING, Value:strconv.Quote(e)}})
-    }
+  // flags that were explicitly set take precedence over config files
+  cli, err := flagSettings(cmdline, imports, tables, urls)
+  if err != nil {
+    fmt.Printf("%v: %v\n", CMD, err)
+    return
   }
   
-  opts := optionNone
+  var dirs []string
   for _, f := range cmdline.Args() {
     
     info, err := os.Stat(f)
@@ -184,7 +315,51 @@ func main() {
       continue
     }
     
-    err = procDir(f, opts)
+    dirs = append(dirs, f)
+  }
+  
+  opts := optionNone
+  if *fWatch && sub == cmdGenerate {
+    err = watch(dirs, cli, opts)
+    if err != nil {
+      fmt.Printf("%v: %v\n", CMD, err)
+    }
+    return
+  }
+  
+  var fields []listField
+  for _, f := range dirs {
+    
+    cnf, err := loadConfig(f, cli)
+    if err != nil {
+      fmt.Printf("%v: %v\n", CMD, err)
+      return
+    }
+    
+    switch sub {
+      case cmdConfig:
+        err = cnf.Write(os.Stdout)
+      case cmdClean:
+        cnf.Apply()
+        err = cleanDir(f)
+      case cmdList, cmdGraph:
+        var l []listField
+        cnf.Apply()
+        l, err = listDir(f, opts)
+        fields = append(fields, l...)
+      case cmdSchema:
+        cnf.Apply()
+        err = writeSchemas(f, *fOut, opts)
+      case cmdTypeScript:
+        cnf.Apply()
+        err = writeTypeScript(f, *fOut, opts)
+      case cmdProto:
+        cnf.Apply()
+        err = writeProto(f, *fOut, *fProtoPkg, *fGoPkg, opts)
+      default:
+        cnf.Apply()
+        err = procDir(f, opts)
+    }
     if err != nil {
       fmt.Printf("%v: %v\n", CMD, err)
       return
@@ -192,9 +367,37 @@ func main() {
     
   }
   
+  if sub == cmdGenerate || sub == cmdClean {
+    err = flushOverlays()
+    if err != nil {
+      fmt.Printf("%v: %v\n", CMD, err)
+      return
+    }
+  }
+  
+  if sub == cmdGraph {
+    err = writeGraph(os.Stdout, buildGraph(fields, *fCross), *fFormat)
+    if err != nil {
+      fmt.Printf("%v: %v\n", CMD, err)
+      return
+    }
+  }
+  
+  if sub == cmdList {
+    if *fJSON {
+      err = writeListJSON(os.Stdout, fields)
+    }else{
+      err = writeListTable(os.Stdout, fields)
+    }
+    if err != nil {
+      fmt.Printf("%v: %v\n", CMD, err)
+      return
+    }
+  }
+  
 }
 
-func procDir(dir string, opts options) error {
+func parseDir(dir string) (*token.FileSet, map[string]*ast.Package, error) {
   fset := token.NewFileSet()
   
   excludeGenerated := func(info os.FileInfo) bool {
@@ -202,11 +405,27 @@ func procDir(dir string, opts options) error {
   }
   
   pkgs, err := parser.ParseDir(fset, dir, excludeGenerated, parser.ParseComments)
+  if err != nil {
+    return nil, nil, err
+  }
+  
+  return fset, pkgs, nil
+}
+
+func procDir(dir string, opts options) error {
+  fset, pkgs, err := parseDir(dir)
   if err != nil {
     return err
   }
   
-  for pname, pkg := range pkgs {
+  pnames := make([]string, 0, len(pkgs))
+  for k := range pkgs {
+    pnames = append(pnames, k)
+  }
+  sort.Strings(pnames)
+  
+  for _, pname := range pnames {
+    pkg := pkgs[pname]
     err := procPackage(newContext(pname, extraImports, opts), fset, dir, pkg)
     if err != nil {
       return err
@@ -218,66 +437,104 @@ func procDir(dir string, opts options) error {
 
 func procPackage(cxt *context, fset *token.FileSet, dir string, pkg *ast.Package) error {
   var err error
+  cxt.Dir = dir
   
-  for fname, file := range pkg.Files {
-    src, dst := fname, refFile(fname)
-    var ood bool
-    if DEBUG || FORCE {
-      ood = true // always out of date for debug or force-generate
-    }else{
-      ood, err = isFileOutOfDate(dst, src)
-      if err != nil {
-        return err
-      }
+  if sharing() {
+    err = prepareShared(cxt, dir)
+    if err != nil {
+      return err
     }
-    if ood {
-      err := procAST(cxt, fset, pkg.Name, src, dst, file)
-      if err != nil {
-        return err
-      }
+  }
+  
+  if overlayDir != "" {
+    err = overlayReset(dir)
+    if err != nil {
+      return err
     }
   }
   
-  if len(cxt.Generate) > 0 || len(cxt.Marshal) > 0 {
-    outpkg := path.Join(dir, pkgSrc + fileSuffix +".go")
-    out, err := refWriter(outpkg)
+  fnames := make([]string, 0, len(pkg.Files))
+  for k := range pkg.Files {
+    fnames = append(fnames, k)
+  }
+  sort.Strings(fnames)
+  
+  for _, fname := range fnames {
+    src, file := fname, pkg.Files[fname]
+    err := checkSharedName(src)
     if err != nil {
       return err
     }
-    
-    if buildTag != "" {
-      fmt.Fprintf(out, "// %s\n\n", buildTag)
+    dst, err := outputFile(src)
+    if err != nil {
+      return err
     }
-    
-    fmt.Fprintf(out, `// This file was generated by Go-Ref. Changes will be overwritten.
-// %v
-package %v
-
-import (
-  ref_fmt "fmt"
-  ref_reflect "reflect"
-  ref_json "encoding/json"
-)
-`, outpkg, cxt.Package)
-    
-    if len(cxt.Deps) > 0 {
-      fmt.Fprintf(out, "\n// Dependency imports\nimport (\n")
-      for _, e := range cxt.Deps {
-        fmt.Fprintf(out, "  ")
-        printSource(out, fset, e)
-        fmt.Fprintf(out, "\n")
+    // every file is processed so the package output is complete, and every
+    // copy is rewritten, since the names it refers to are chosen for the
+    // whole package; only those that change are written
+    err = procAST(cxt, fset, pkg.Name, src, dst, file, true)
+    if err != nil {
+      return err
+    }
+  }
+  
+  // generated names can only be chosen once the whole package is known
+  err = resolveNames(cxt)
+  if err != nil {
+    return fmt.Errorf("%v: %v", dir, err)
+  }
+  for _, e := range cxt.Copies {
+    err = writeCopy(cxt, fset, e)
+    if err != nil {
+      return err
+    }
+  }
+  
+  if len(cxt.Generate) > 0 || len(cxt.Marshal) > 0 {
+    outpkg := path.Join(dir, pkgSrc + fileSuffix +".go")
+    name := outpkg
+    if overlayDir != "" && overlayPkg {
+      dst, err := overlayPath(outpkg)
+      if err != nil {
+        return err
       }
-      fmt.Fprintf(out, ")\n")
+      err = overlayReplace(outpkg, dst)
+      if err != nil {
+        return err
+      }
+      outpkg = dst
     }
     
-    for _, v := range cxt.Generate {
+    // the body is generated first so we know what it needs to import
+    out := &bytes.Buffer{}
+    
+    // output is ordered so it's the same from one run to the next: types
+    // by name and marshalers in the order their structs are declared
+    for _, v := range cxt.Generate.Sorted() {
       err := genType(cxt, out, fset, v)
       if err != nil {
         return err
       }
+      err = genResolver(cxt, out, v)
+      if err != nil {
+        return err
+      }
+      err = genHTTPResolver(cxt, out, v)
+      if err != nil {
+        return err
+      }
+      err = genCache(cxt, out, v)
+      if err != nil {
+        return err
+      }
     }
     
-    for _, v := range cxt.Marshal {
+    marshal := cxt.Marshal.Sorted()
+    sort.SliceStable(marshal, func(i, j int) bool {
+      a, b := cxt.Types[marshal[i].Name], cxt.Types[marshal[j].Name]
+      return a != nil && b != nil && a.Pos() < b.Pos()
+    })
+    for _, v := range marshal {
       err := genMarshal(cxt, out, fset, v)
       if err != nil {
         return err
@@ -287,9 +544,13 @@ import (
         return err
       }
     }
+    err = genRegistry(cxt, out, fset)
+    if err != nil {
+      return err
+    }
     
     routines := `
-func isEmptyValue(v ref_reflect.Value) bool {
+func `+ cxt.IsEmpty +`(v ref_reflect.Value) bool {
   switch v.Kind() {
   case ref_reflect.Array, ref_reflect.Map, ref_reflect.Slice, ref_reflect.String:
     return v.Len() == 0
@@ -308,16 +569,81 @@ func isEmptyValue(v ref_reflect.Value) bool {
 }
 `
     fmt.Fprint(out, routines)
+    
+    imports, err := generatedImports(cxt, out.Bytes())
+    if err != nil {
+      return fmt.Errorf("%v: %v", outpkg, err)
+    }
+    
+    // assemble and format the file; line directives are resolved last
+    // since formatting moves lines around
+    gen := &bytes.Buffer{}
+    writeBuildLine(gen)
+    
+    fmt.Fprintf(gen, "// %s. Changes will be overwritten.\n// %v\n", generatedHeader, outpkg)
+    for _, e := range sharedOwners(cxt) {
+      if cxt.Shared[e].UsedBy(cxt.ImportPath) {
+        fmt.Fprintf(gen, "%s %s\n", usesDirective, e)
+      }
+    }
+    fmt.Fprintf(gen, "package %v\n", cxt.Package)
+    if imports != "" {
+      fmt.Fprint(gen, "\n"+ imports)
+    }
+    gen.Write(out.Bytes())
+    data, err := format.Source(gen.Bytes())
+    if err != nil {
+      return fmt.Errorf("%v: generated code is invalid: %v", outpkg, err)
+    }
+    err = cxt.Output.Add(outpkg, name, resolveLineResets(data, outpkg))
+    if err != nil {
+      return err
+    }
   }
   
-  return nil
+  for _, e := range sharedOwners(cxt) {
+    out, err := cxt.Shared[e].Generate()
+    if err != nil {
+      return err
+    }
+    err = cxt.Output.Append(out)
+    if err != nil {
+      return err
+    }
+  }
+  
+  if typeCheck {
+    err = typeCheckPackage(dir, cxt.Output)
+    if err != nil {
+      return err
+    }
+  }
+  
+  // nothing is written unless everything succeeded
+  return cxt.Output.Commit()
+}
+
+/**
+ * Write the build constraint or tag that generated files start with, if any
+ */
+func writeBuildLine(w io.Writer) {
+  if constraintTag != "" {
+    fmt.Fprintf(w, "%s\n\n", constraintLine(&constraint.TagExpr{Tag:constraintTag}))
+  }else if buildTag != "" {
+    fmt.Fprintf(w, "// %s\n\n", buildTag)
+  }
 }
 
-func procAST(cxt *context, fset *token.FileSet, pkg, src, dst string, file *ast.File) error {
-  pkgrefs := make(map[string]int)
+func procAST(cxt *context, fset *token.FileSet, pkg, src, dst string, file *ast.File, write bool) error {
   fcxt := &source{}
   nerr := 0
   
+  // generated names must not collide with anything declared in the package
+  for _, e := range file.Decls {
+    cxt.Decls.AddDecl(e)
+    cxt.Methods.AddMethod(e) // for the types that marshal themselves
+  }
+  
   // check the first line comment group for macro directives
   if len(file.Comments) > 0 {
     for _, e := range file.Comments[0].List {
@@ -325,7 +651,7 @@ func procAST(cxt *context, fset *token.FileSet, pkg, src, dst string, file *ast.
       if c == macro {
         c, t = args(t)
         if c == macroIgnore {
-          if VERBOSE {
+          if VERBOSE && write {
             fmt.Printf("%v: skipping ignored source: %v\n", CMD, src)
           }
           return nil
@@ -334,89 +660,121 @@ func procAST(cxt *context, fset *token.FileSet, pkg, src, dst string, file *ast.
     }
   }
   
-  // traverse the source first to handle types
-  ast.Inspect(file, func(n ast.Node) bool {
-    switch t := n.(type) {
+  // handle the types declared at package scope first; ref fields anywhere
+  // else, such as in function-local types, are rejected
+  for _, d := range file.Decls {
+    var err error
+    switch t := d.(type) {
       case *ast.GenDecl:
-        err := typeSpecs(cxt, fcxt, fset, t.Specs)
-        if err != nil {
-          fmt.Printf("%v: %v: %v\n", CMD, src, err)
-          nerr++
+        if t.Tok == token.VAR || t.Tok == token.CONST {
+          err = checkRefScope(fset, t)
+        }else{
+          err = typeSpecs(cxt, fcxt, fset, t.Specs)
         }
+      case *ast.FuncDecl:
+        err = checkRefScope(fset, t)
     }
-    return true
-  })
-  
-  if nerr < 1 && fcxt.Generate > 0 {
-    
-    // traverse the source a second time to compile a set of package references
-    ast.Inspect(file, func(n ast.Node) bool {
-      switch t := n.(type) {
-        case *ast.Ident:
-          c := pkgrefs[t.Name]
-          c++
-          pkgrefs[t.Name] = c
-          return false
-        // case *ast.SelectorExpr:
-        //   e := leftmost(t)
-        //   if id, ok := e.(*ast.Ident); ok {
-        //     n := pkgrefs[id.Name]
-        //     n++
-        //     pkgrefs[id.Name] = n
-        //   }
-        //   return false
-      }
-      return true
-    })
-    
-    // trim unused imports from decls (this is what's actually used by the printer)
-    // file.Imports seems to just be a higher-level convenience, which we don't bother with
-    for _, e := range file.Decls {
-      if g, ok := e.(*ast.GenDecl); ok {
-        if g.Tok == token.IMPORT {
-          for i, s := range g.Specs {
-            if m, ok := s.(*ast.ImportSpec); ok {
-              p := importPackage(m)
-              if _, ok := pkgrefs[p]; !ok {
-                g.Specs[i] = &ast.ImportSpec{
-                  Path: &ast.BasicLit{
-                    ValuePos: m.Path.ValuePos,
-                    Kind: m.Path.Kind,
-                    Value: "// "+ m.Path.Value,
-                  },
-                }
-              }
-            }
-          }
-        }
-      }
+    if _, ok := err.(scanner.Error); ok {
+      fmt.Printf("%v: %v\n", CMD, err) // already positioned
+      nerr++
+    }else if err != nil {
+      fmt.Printf("%v: %v: %v\n", CMD, src, err)
+      nerr++
     }
-    
-    w, err := refWriter(dst)
+  }
+  
+  // the copy replaces the original in the overlay whether or not it needs
+  // to be written again
+  if nerr < 1 && fcxt.Generate > 0 && overlayDir != "" {
+    err := overlayReplace(src, dst)
     if err != nil {
       return err
     }
+  }
+  
+  if nerr < 1 && fcxt.Generate > 0 && write {
     
-    if buildTag != "" {
-      fmt.Fprintf(w, "// %s\n\n", buildTag)
+    // rewritten fields no longer refer to the packages that declare the
+    // referenced types, so some imports may no longer be used; a shared
+    // package may be needed instead
+    if sharedPkg != "" {
+      astutil.AddNamedImport(fset, file, sharedImport, sharedPkg)
     }
+    trimImports(fset, file)
     
-    fmt.Fprintf(w, strings.TrimSpace(`
-// This file was generated by Go-Ref from the source file:
-// > %v
-// Changes will be overwritten.
-    `) +"\n", src)
+    // the copy gets a constraint combining the generated tag with whatever
+    // the original requires; the original's own constraint lines are dropped
+    var gen constraint.Expr
+    if constraintTag != "" && overlayDir == "" {
+      orig, lines, err := fileConstraint(file)
+      if err != nil {
+        return fmt.Errorf("%v: %v", src, err)
+      }
+      gen = copyConstraint(orig, constraintTag)
+      stripConstraints(file, lines)
+      err = checkConstraints(cxt, src, orig, gen, constraintTag)
+      if err != nil {
+        return err
+      }
+    }
     
-    // strip comments if necessary
+    // strip directives that only concern the original; docs are kept
     if stripComments {
-      file.Comments = nil
+      stripDirectives(fset, file)
     }
     
-    printSource(w, fset, file)
+    cxt.Copies = append(cxt.Copies, &sourceCopy{src, dst, file, gen})
   }
   return nil
 }
 
+/**
+ * Print a rewritten source
+ */
+func writeCopy(cxt *context, fset *token.FileSet, c *sourceCopy) error {
+  w := &bytes.Buffer{}
+  if c.Constraint != nil {
+    fmt.Fprintf(w, "%s\n\n", constraintLine(c.Constraint))
+  }else if buildTag != "" {
+    fmt.Fprintf(w, "// %s\n\n", buildTag)
+  }
+  
+  fmt.Fprintf(w, strings.TrimSpace(`
+// %s from the source file:
+// > %v
+// Changes will be overwritten.
+  `) +"\n", generatedHeader, c.Source)
+  
+  // positions in the copy map back to the original
+  err := printSourceLines(w, fset, c.File, c.Source)
+  if err != nil {
+    return err
+  }
+  err = checkSource(c.Dest, w.Bytes())
+  if err != nil {
+    return err
+  }
+  
+  // an up-to-date copy is left alone unless it would change, which it does
+  // when a ref type it refers to is renamed for a file added since
+  if !DEBUG && !FORCE {
+    ood, err := isFileOutOfDate(c.Dest, c.Source)
+    if err != nil {
+      return err
+    }
+    if curr, err := os.ReadFile(c.Dest); !ood && err == nil && bytes.Equal(curr, w.Bytes()) {
+      return nil
+    }
+  }
+  
+  // in an overlay the copy stands in for the original
+  name := c.Dest
+  if overlayDir != "" {
+    name = c.Source
+  }
+  return cxt.Output.Add(c.Dest, name, w.Bytes())
+}
+
 func typeSpecs(cxt *context, src *source, fset *token.FileSet, s []ast.Spec) error {
   for _, e := range s {
     switch v := e.(type) {
@@ -424,7 +782,7 @@ func typeSpecs(cxt *context, src *source, fset *token.FileSet, s []ast.Spec) err
         cxt.Imports.Add(v)
       case *ast.TypeSpec:
         cxt.Types.Add(v)
-        gen, err := typeExpr(cxt, src, fset, v.Type)
+        gen, err := typeExpr(cxt, src, fset, v.Type, v.TypeParams)
         if err != nil {
           return err
         }
@@ -436,12 +794,17 @@ func typeSpecs(cxt *context, src *source, fset *token.FileSet, s []ast.Spec) err
   return nil
 }
 
-func typeExpr(cxt *context, src *source, fset *token.FileSet, e ast.Expr) (bool, error) {
+func typeExpr(cxt *context, src *source, fset *token.FileSet, e ast.Expr, tparams *ast.FieldList) (bool, error) {
   var err error
   var gen bool
   switch v := e.(type) {
     case *ast.StructType:
-      gen, err = structType(cxt, src, fset, v)
+      gen, err = structType(cxt, src, fset, v, tparams)
+      if err != nil {
+        return false, err
+      }
+    default:
+      err = checkRefScope(fset, v)
       if err != nil {
         return false, err
       }
@@ -449,7 +812,7 @@ func typeExpr(cxt *context, src *source, fset *token.FileSet, e ast.Expr) (bool,
   return gen, nil
 }
 
-func structType(cxt *context, src *source, fset *token.FileSet, s *ast.StructType) (bool, error) {
+func structType(cxt *context, src *source, fset *token.FileSet, s *ast.StructType, tparams *ast.FieldList) (bool, error) {
   deps := make(importSet)
   var gen bool
   
@@ -474,13 +837,26 @@ func structType(cxt *context, src *source, fset *token.FileSet, s *ast.StructTyp
         }
       }
       
+      // an anonymous struct gets its ref fields rewritten like any other and
+      // is marshaled inline by its parent; anywhere else they are unsupported
+      if a, _, ok := anonStruct(e.Type); ok && !isRefField(e) {
+        g, err := structType(cxt, src, fset, a, tparams)
+        if err != nil {
+          return false, err
+        }
+        gen = gen || g
+        continue
+      }else if err := checkRefScope(fset, e.Type); err != nil {
+        return false, err
+      }
+      
       if e.Tag != nil  && e.Tag.Kind == token.STRING {
         tag, err := strconv.Unquote(e.Tag.Value)
         if err != nil {
           return false, err
         }
         t := reflect.StructTag(tag)
-        if ref := t.Get(refTag); ref != "" {
+        if ref := t.Get(reftag.Tag); ref != "" {
           
           id, err := parseIdent(e.Type)
           if err != nil {
@@ -489,17 +865,48 @@ func structType(cxt *context, src *source, fset *token.FileSet, s *ast.StructTyp
           if !ast.IsExported(id.Base) {
             return false, fmt.Errorf("Field must be exported: %v", id.Base)
           }
+          if p, ok := typeParamRef(e.Type, tparams); ok {
+            // ref types are declared at package scope, where it isn't defined
+            return false, fmt.Errorf("Referenced type cannot depend on a type parameter: %v", p)
+          }
           
-          genId := ast.NewIdent(id.Base + refSuffix)
+          name := id
+          if len(e.Names) > 0 {
+            name = astIdent(e.Names[0])
+          }
+          policy, err := reftag.Parse(e, name.Base)
+          if err != nil {
+            return false, err
+          }
+          
+          genType, shared, err := sharedRefType(cxt, id, policy.Type)
+          if err != nil {
+            return false, err
+          }
+          if policy.Table != "" || policy.URL != "" {
+            if shared {
+              return false, fmt.Errorf("Resolvers are not generated for shared ref types: %v", id.Name)
+            }
+            err = addMapping(cxt.Tables, id, policy.Table, "SQL tables")
+            if err != nil {
+              return false, err
+            }
+            err = addMapping(cxt.URLs, id, policy.URL, "URL templates")
+            if err != nil {
+              return false, err
+            }
+          }
+          if !shared {
+            genType = cxt.Generate.Add(id, policy.Type)
+          }
           s.Fields.List[i] = &ast.Field{
             Names:e.Names,
-            Type:indirect(genId, 1),
+            Type:&ast.StarExpr{X:genType},
+            Doc:e.Doc,
             Comment:e.Comment,
             Tag:e.Tag,
           }
           
-          cxt.Generate.Add(id)
-          cxt.Lookup[genId.Name] = id
           src.Generate++
           gen = true
         }
@@ -517,18 +924,29 @@ func structType(cxt *context, src *source, fset *token.FileSet, s *ast.StructTyp
   return gen, nil
 }
 
-func genType(cxt *context, w io.Writer, fset *token.FileSet, id *ident) error {
+func genType(cxt *context, w io.Writer, fset *token.FileSet, ref *refType) error {
+  id := ref.Ident
   
   var inds int
   if !id.Nullable() {
     inds++
   }
   
-  refId := id.Base + refSuffix
+  refId := ref.Name
+  vtype := repeat(inds, '*') + id.Name
+  
+  // a lazy ref's loading state is behind a pointer, so a ref can still be
+  // copied, as HasValue does
+  var lstate, lfield string
+  if lazyRefs {
+    lstate = fmt.Sprintf("struct {\n  ref_sync.Mutex\n  loader %v\n  cache  bool\n  err    error\n}", refId + loaderSuffix)
+    lfield = "\n  \n  load  *"+ strings.Replace(lstate, "\n", "\n  ", -1)
+  }
+  
   tspec := fmt.Sprintf(`
 type %v struct {
   Id    %v
-  Value %v
+  Value %v`+ lfield +`
 }
 
 func New%v(v %v) *%v {
@@ -542,13 +960,165 @@ func New%vId(v %v) *%v {
 func (v %v) HasValue() bool {
   return v.Value != nil
 }`,
-  refId, idType, repeat(inds, '*') + id.Name,
-  refId, repeat(inds, '*') + id.Name, refId,
+  refId, idType, vtype,
+  refId, vtype, refId,
   refId,
   refId, idType, refId,
   refId,
   refId)
   
+  // refs are stored in a database as their ids; the Value field rules out a
+  // Value method, so a column type is the driver.Valuer
+  tspec += fmt.Sprintf(`
+  
+// Scan sets the id from a database column. NULL leaves the ref empty; use a
+// %[1]v%[3]v to scan a nullable column into a nil ref.
+//
+// An id type that is a sql.Scanner scans the column itself. Otherwise values
+// the id can hold, like []byte, int64 or time.Time, are kept as they are, and
+// others are converted through their text.
+func (v *%[1]v) Scan(src interface{}) error {
+  var id %[2]v
+  v.Id, v.Value = id, nil
+  switch s := src.(type) {
+    case nil:
+      return nil
+    case []byte:
+      src = append([]byte(nil), s...) // the driver may reuse its buffer
+  }
+  if s, ok := interface{}(&id).(ref_sql.Scanner); ok {
+    if err := s.Scan(src); err != nil {
+      return ref_fmt.Errorf("Cannot scan %%T into the id of a %[1]v: %%v", src, err)
+    }
+    v.Id = id
+    return nil
+  }
+  d, x := ref_reflect.ValueOf(&id).Elem(), ref_reflect.ValueOf(src)
+  if x.Type().AssignableTo(d.Type()) {
+    d.Set(x)
+    v.Id = id
+    return nil
+  }
+  var text string
+  switch s := src.(type) {
+    case []byte:
+      text = string(s)
+    case ref_time.Time:
+      text = s.Format(ref_time.RFC3339Nano)
+    default:
+      text = ref_fmt.Sprint(src)
+  }
+  if d.Kind() == ref_reflect.String {
+    d.SetString(text)
+  }else if _, err := ref_fmt.Sscan(text, &id); err != nil {
+    return ref_fmt.Errorf("Cannot scan %%T into the id of a %[1]v: %%v", src, err)
+  }
+  v.Id = id
+  return nil
+}
+
+// %[1]v%[3]v adapts a %[1]v field to a database column of its id. It scans
+// NULL as a nil ref and stores a nil ref as NULL. When only the value is set,
+// the id is taken from its Id or ID field.
+type %[1]v%[3]v struct {
+  Ref **%[1]v
+}
+
+func New%[1]v%[3]v(r **%[1]v) %[1]v%[3]v {
+  return %[1]v%[3]v{Ref:r}
+}
+
+func (c %[1]v%[3]v) Scan(src interface{}) error {
+  if src == nil {
+    *c.Ref = nil
+    return nil
+  }
+  r := *c.Ref
+  if r == nil {
+    r = &%[1]v{}
+  }
+  err := r.Scan(src)
+  if err != nil {
+    return err
+  }
+  *c.Ref = r
+  return nil
+}
+
+func (c %[1]v%[3]v) Value() (ref_driver.Value, error) {
+  r := *c.Ref
+  if r == nil {
+    return nil, nil
+  }
+  id := r.Id
+  zero := func() bool {
+    return ref_reflect.ValueOf(&id).Elem().IsZero() // ids need not be comparable
+  }
+  if zero() && r.Value != nil {
+    if x := ref_reflect.Indirect(ref_reflect.ValueOf(r.Value)); x.Kind() == ref_reflect.Struct {
+      for _, n := range []string{"Id", "ID"} {
+        f := x.FieldByName(n)
+        if f.IsValid() && f.Type().ConvertibleTo(ref_reflect.TypeOf(id)) {
+          id = f.Convert(ref_reflect.TypeOf(id)).Interface().(%[2]v)
+          break
+        }
+      }
+    }
+    if zero() {
+      return nil, ref_fmt.Errorf("%[1]v has a value with no id to store")
+    }
+  }else if zero() {
+    return nil, nil
+  }
+  return ref_driver.DefaultParameterConverter.ConvertValue(id)
+}`,
+  refId, idType, columnSuffix)
+  
+  if lazyRefs {
+    tspec += fmt.Sprintf(`
+    
+// %[1]v%[4]v loads the value a %[1]v refers to by its id
+type %[1]v%[4]v func(ref_context.Context, %[2]v) (%[3]v, error)
+
+// WithLoader attaches a loader, which Get uses to load the value on first use.
+// Errors are returned but not kept, so a later Get tries again, unless
+// cacheErrors is set.
+func (v *%[1]v) WithLoader(l %[1]v%[4]v, cacheErrors bool) *%[1]v {
+  v.load = &%[5]v{loader:l, cache:cacheErrors}
+  return v
+}
+
+// Get returns the value, loading it with the attached loader on first use. It
+// is safe to call from multiple goroutines; concurrent callers wait for a
+// single load. The Value field may be read directly once Get has returned.
+func (v *%[1]v) Get(ctx ref_context.Context) (%[3]v, error) {
+  if v.load == nil {
+    if v.Value == nil {
+      return nil, ref_fmt.Errorf("%[1]v has no value and no loader: %%v", v.Id)
+    }
+    return v.Value, nil
+  }
+  v.load.Lock()
+  defer v.load.Unlock()
+  if v.Value != nil {
+    return v.Value, nil
+  }
+  if v.load.err != nil {
+    return nil, v.load.err
+  }
+  val, err := v.load.loader(ctx, v.Id)
+  if err != nil {
+    if v.load.cache {
+      v.load.err = err
+    }
+    return nil, err
+  }
+  v.Value = val
+  return val, nil
+}`,
+    refId, idType, vtype, loaderSuffix, lstate)
+  }
+  
   fmt.Fprint(w, "\n"+ strings.TrimSpace(tspec) +"\n")
   return nil
 }
@@ -565,10 +1135,42 @@ func genMarshal(cxt *context, w io.Writer, fset *token.FileSet, id *ident) error
     return fmt.Errorf("Base type must be a struct: %s", id.Name)
   }
   
-  decl := fmt.Sprintf(`func (v %s) MarshalJSON() ([]byte, error) {`, id.Name)
+  decl := fmt.Sprintf(`func (v %s) MarshalJSON() ([]byte, error) {`, recvType(spec))
   var defX, defErr int
   
   marshal := `  fc := 0` +"\n"+ `  s := "{"` +"\n\n"
+  fields, err := marshalFields(cxt, base, &defX, &defErr)
+  if err != nil {
+    return err
+  }
+  marshal += fields
+  
+  marshal += `  s += "}"` + "\n"
+  if TRACE {
+    marshal += fmt.Sprintf(`  ref_fmt.Println(">>>", %q, s)`, id.Name) + "\n"
+  }
+  marshal += `  return []byte(s), nil
+}`
+  
+  fmt.Fprint(w, "\n"+ declLine(fset, spec) + decl +"\n")
+  if defErr > 0 {
+    fmt.Fprint(w, "  var err error\n")
+  }
+  if defX > 0 {
+    fmt.Fprint(w, "  var x []byte\n")
+  }
+  fmt.Fprint(w, marshal +"\n"+ lineReset +"\n")
+  
+  return nil
+}
+
+/**
+ * Produce the marshaling logic for the fields of a struct. Anonymous structs
+ * that have ref fields are marshaled inline by the same logic, shadowing the
+ * value being marshaled and its field count.
+ */
+func marshalFields(cxt *context, base *ast.StructType, defX, defErr *int) (string, error) {
+  var marshal string
   if base.Fields != nil {
     fields:
     for _, e := range base.Fields.List {
@@ -576,15 +1178,15 @@ func genMarshal(cxt *context, w io.Writer, fset *token.FileSet, id *ident) error
         
         id, err := parseIdent(v)
         if err != nil {
-          return err
+          return "", err
         }
           if !ast.IsExported(id.Base) {
           continue // ignore unexported fields
         }
         
-        policy, err := fieldMarshalPolicy(e, id)
+        policy, err := reftag.Parse(e, id.Base)
         if err != nil {
-          return err
+          return "", err
         }
         if policy.Omit {
           continue fields
@@ -592,8 +1194,8 @@ func genMarshal(cxt *context, w io.Writer, fset *token.FileSet, id *ident) error
         
         marshal += fmt.Sprintf(`  // %s`, id.Base) +"\n"
         if policy.Ref {
-          defX++; defErr++
-          if policy.Marshal == marshalValue {
+          *defX++; *defErr++
+          if policy.Marshal == reftag.Value {
             marshal += indent(1, fmt.Sprintf(strings.TrimSpace(`
 if v.%s != nil {
   if v.%s.HasValue() {
@@ -611,10 +1213,10 @@ if v.%s != nil {
   }
 }
 `),         id.Base, id.Base, policy.Names.Value, id.Base)) +"\n"
-          }else if policy.Marshal == marshalId {
+          }else if policy.Marshal == reftag.Id {
             marshal += indent(1, fmt.Sprintf(strings.TrimSpace(`
 if v.%s != nil {
-  if v.%s.Id != "" {
+  if !%s(ref_reflect.ValueOf(v.%s.Id)) {
     if fc > 0 { s += "," }; fc++
     x, err = ref_json.Marshal(%q)
     if err != nil {
@@ -628,15 +1230,35 @@ if v.%s != nil {
     s += string(x)
   }
 }
-`),         id.Base, id.Base, policy.Names.Id, id.Base)) +"\n"
+`),         id.Base, cxt.IsEmpty, id.Base, policy.Names.Id, id.Base)) +"\n"
           }else{
-            return fmt.Errorf("Invalid marshaling variant: %v", policy.Marshal)
+            return "", fmt.Errorf("Invalid marshaling variant: %v", policy.Marshal)
           }
         }else{
-          defX++; defErr++
+          *defX++; *defErr++
+          
+          // an anonymous struct with ref fields is marshaled inline
+          var inline string
+          if a, ptr, ok := anonStruct(e.Type); ok && hasRefFields(a) {
+            nested, err := marshalFields(cxt, a, defX, defErr)
+            if err != nil {
+              return "", err
+            }
+            if nested == "" {
+              inline = `s += "{}"`
+            }else{
+              inline = `  fc := 0` +"\n"+ `  s += "{"` +"\n\n"+ nested + `  s += "}"` +"\n"
+              if ptr {
+                inline = fmt.Sprintf("if v.%s == nil {\n  s += \"null\"\n}else{\n  v := *v.%s\n", id.Base, id.Base) + inline +"}"
+              }else{
+                inline = fmt.Sprintf("{\n  v := v.%s\n", id.Base) + inline +"}"
+              }
+            }
+          }
+          
           iv := 1
           if policy.OmitEmpty {
-            marshal += fmt.Sprintf(`  if !isEmptyValue(ref_reflect.ValueOf(v.%s)) {`, id.Base) + "\n"
+            marshal += fmt.Sprintf(`  if !%s(ref_reflect.ValueOf(v.%s)) {`, cxt.IsEmpty, id.Base) + "\n"
             iv++
           }
           marshal += indent(iv, fmt.Sprintf(strings.TrimSpace(`
@@ -646,12 +1268,18 @@ if err != nil {
   return nil, err
 }
 s += ref_fmt.Sprintf("%%s:", string(x))
+`),         policy.Names.Value)) +"\n"
+          if inline != "" {
+            marshal += indent(iv, inline) +"\n"
+          }else{
+            marshal += indent(iv, fmt.Sprintf(strings.TrimSpace(`
 x, err = ref_json.Marshal(v.%s)
 if err != nil {
   return nil, err
 }
 s += string(x)
-`),         policy.Names.Value, id.Base)) +"\n"
+`),         id.Base)) +"\n"
+          }
           if policy.OmitEmpty {
             marshal += `  }` +"\n"
           }
@@ -663,23 +1291,7 @@ s += string(x)
     }
   }
   
-  marshal += `  s += "}"` + "\n"
-  if TRACE {
-    marshal += fmt.Sprintf(`  ref_fmt.Println(">>>", %q, s)`, id.Name) + "\n"
-  }
-  marshal += `  return []byte(s), nil
-}`
-  
-  fmt.Fprint(w, "\n"+ decl +"\n")
-  if defErr > 0 {
-    fmt.Fprint(w, "  var err error\n")
-  }
-  if defX > 0 {
-    fmt.Fprint(w, "  var x []byte\n")
-  }
-  fmt.Fprint(w, marshal +"\n")
-  
-  return nil
+  return marshal, nil
 }
 
 func genUnmarshal(cxt *context, w io.Writer, fset *token.FileSet, id *ident) error {
@@ -694,7 +1306,7 @@ func genUnmarshal(cxt *context, w io.Writer, fset *token.FileSet, id *ident) err
     return fmt.Errorf("Base type must be a struct: %s", id.Name)
   }
   
-  decl := fmt.Sprintf(`func (v *%s) UnmarshalJSON(data []byte) error {`, id.Name)
+  decl := fmt.Sprintf(`func (v *%s) UnmarshalJSON(data []byte) error {`, recvType(spec))
   var defX, defErr int
   
   marshal := indent(1, strings.TrimSpace(fmt.Sprintf(`
@@ -705,41 +1317,99 @@ err := ref_json.Unmarshal(data, &fields)
 if err != nil {
   return err
 }
-`, id.Name))) +"\n"
+`, recvType(spec)))) +"\n"
+  
+  fields, err := unmarshalFields(cxt, fset, base)
+  if err != nil {
+    return err
+  }
+  marshal += fields
+  
+  if TRACE {
+    marshal += "\n"
+    marshal += fmt.Sprintf(`  ref_fmt.Printf("<<< %s %%+v\n", fields)`, id.Name)
+  }
+  marshal += "\n"
+  marshal += "  *v = x\n"
+  marshal += "  return nil\n"
+  marshal += `}`
   
+  fmt.Fprint(w, "\n"+ declLine(fset, spec) + decl +"\n")
+  if defErr > 0 {
+    fmt.Fprint(w, "  var err error\n")
+  }
+  if defX > 0 {
+    fmt.Fprint(w, "  var x []byte\n")
+  }
+  fmt.Fprint(w, marshal +"\n"+ lineReset +"\n")
+  
+  return nil
+}
+
+/**
+ * Produce the unmarshaling logic for the fields of a struct, which are
+ * collected from the JSON object into the map named fields and assigned to the
+ * struct named x.
+ */
+func unmarshalFields(cxt *context, fset *token.FileSet, base *ast.StructType) (string, error) {
+  var marshal string
   if base.Fields != nil {
     fields:
     for _, e := range base.Fields.List {
+      if a, ptr, ok := anonStruct(e.Type); ok {
+        for _, v := range e.Names {
+          if !ast.IsExported(v.Name) {
+            continue // ignore unexported fields
+          }
+          policy, err := reftag.Parse(e, v.Name)
+          if err != nil {
+            return "", err
+          }
+          if policy.Omit {
+            continue fields
+          }
+          inline, err := unmarshalAnon(cxt, fset, a, ptr, v.Name, policy)
+          if err != nil {
+            return "", err
+          }
+          marshal += "\n"
+          marshal += fmt.Sprintf(`  // %s`, v.Name) +"\n"
+          marshal += indent(1, inline) +"\n"
+        }
+        continue
+      }
+      
       ftype, err := parseIdent(e.Type)
       if err != nil {
-        return err
+        return "", err
       }
       for _, v := range e.Names {
         
         id, err := parseIdent(v)
         if err != nil {
-          return err
+          return "", err
         }
         if !ast.IsExported(id.Base) {
           continue // ignore unexported fields
         }
         
-        policy, err := fieldMarshalPolicy(e, id)
+        policy, err := reftag.Parse(e, id.Base)
         if err != nil {
-          return err
+          return "", err
         }
         if policy.Omit {
           continue fields
         }
         
-        rev, ok := cxt.Lookup[ftype.Base]
+        qual, name := refTypeName(ftype)
+        rev, ok := cxt.Lookup[qual + name]
         if !ok {
           rev = ftype
         }
         
         var vassign string
         if policy.Ref {
-          vassign = fmt.Sprintf(`New%v(e)`, ftype.Base)
+          vassign = fmt.Sprintf(`%sNew%v(e)`, qual, name)
         }else{
           vassign = `e`
         }
@@ -761,11 +1431,11 @@ if f, ok := fields[%q]; ok {
   if err != nil {
     return err
   }
-  if !isEmptyValue(ref_reflect.ValueOf(e)) {
+  if !%s(ref_reflect.ValueOf(e)) {
     x.%s = %s
   }
 }
-`,      policy.Names.Value, repeat(inds, '*') + rev.Name, id.Name, vassign)))
+`,      policy.Names.Value, repeat(inds, '*') + rev.Name, cxt.IsEmpty, id.Name, vassign)))
         
         if policy.Ref {
           marshal += strings.TrimSpace(fmt.Sprintf(`
@@ -778,11 +1448,11 @@ else if f, ok = fields[%q]; ok {
   if err != nil {
     return err
   }
-  if !isEmptyValue(ref_reflect.ValueOf(e)) {
-    x.%v = New%vId(e)
+  if !%s(ref_reflect.ValueOf(e)) {
+    x.%v = %sNew%vId(e)
   }
 }
-`,        idType, id.Name, ftype.Base)))
+`,        idType, cxt.IsEmpty, id.Name, qual, name)))
         }
         
         marshal += "\n"
@@ -791,36 +1461,73 @@ else if f, ok = fields[%q]; ok {
     }
   }
   
-  if TRACE {
-    marshal += "\n"
-    marshal += fmt.Sprintf(`  ref_fmt.Printf("<<< %s %%+v\n", fields)`, id.Name)
-  }
-  marshal += "\n"
-  marshal += "  *v = x\n"
-  marshal += "  return nil\n"
-  marshal += `}`
   
-  fmt.Fprint(w, "\n"+ decl +"\n")
-  if defErr > 0 {
-    fmt.Fprint(w, "  var err error\n")
+  return marshal, nil
+}
+
+/**
+ * Produce the unmarshaling logic for an anonymous struct field. One that has
+ * ref fields is unmarshaled inline, shadowing the fields being collected and
+ * the struct being assigned to; any other is unmarshaled directly.
+ */
+func unmarshalAnon(cxt *context, fset *token.FileSet, a *ast.StructType, ptr bool, name string, policy reftag.Policy) (string, error) {
+  var nested string
+  if hasRefFields(a) {
+    var err error
+    nested, err = unmarshalFields(cxt, fset, a)
+    if err != nil {
+      return "", err
+    }
   }
-  if defX > 0 {
-    fmt.Fprint(w, "  var x []byte\n")
+  if nested == "" {
+    return fmt.Sprintf(strings.TrimSpace(`
+if f, ok := fields[%q]; ok {
+  err := ref_json.Unmarshal(f, &x.%s)
+  if err != nil {
+    return err
+  }
+}
+`), policy.Names.Value, name), nil
   }
-  fmt.Fprint(w, marshal +"\n")
   
-  return nil
+  var alloc string
+  if ptr {
+    b := &bytes.Buffer{}
+    err := printer.Fprint(b, fset, a)
+    if err != nil {
+      return "", err
+    }
+    alloc = fmt.Sprintf("x.%s = new(%s)\nx := x.%s", name, b.String(), name)
+  }else{
+    alloc = fmt.Sprintf("x := &x.%s", name)
+  }
+  
+  cond := "ok"
+  if ptr {
+    cond = `ok && string(f) != "null"`
+  }
+  return fmt.Sprintf(strings.TrimSpace(`
+if f, ok := fields[%q]; %s {
+%s
+  fields := make(map[string]ref_json.RawMessage)
+  err := ref_json.Unmarshal(f, &fields)
+  if err != nil {
+    return err
+  }
+%s
+}
+`), policy.Names.Value, cond, indent(1, alloc), indent(1, strings.TrimRight(nested, "\n"))), nil
 }
 
-func refWriter(f string) (io.Writer, error) {
-  if DEBUG {
-    return os.Stdout, nil
+/**
+ * Determine where the rewritten copy of a source goes: beside it, or into
+ * the overlay cache
+ */
+func outputFile(src string) (string, error) {
+  if overlayDir != "" {
+    return overlayPath(src)
   }else{
-    w, err := os.OpenFile(f, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0644)
-    if err != nil {
-      return nil, err
-    }
-    return w, nil
+    return refFile(src), nil
   }
 }
 
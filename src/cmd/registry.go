@@ -0,0 +1,84 @@
+package main
+
+import (
+  "io"
+  "fmt"
+  "strings"
+  "go/token"
+)
+
+/**
+ * Names the ref field registry declares in a package. They are part of its
+ * API, so they are not renamed to avoid a collision; it's an error instead.
+ */
+const (
+  registryField     = "RefField"
+  registryFieldsOf  = "RefFieldsOf"
+  registryStructs   = "RefStructs"
+  registryVar       = "refFieldRegistry"
+)
+
+var registryNames = []string{registryField, registryFieldsOf, registryStructs, registryVar}
+
+/**
+ * Generate the ref field registry for a package: a static description of the
+ * ref fields of every struct that has them, as their tags declare them, so
+ * tooling can query them without reflection or parsing tags.
+ */
+func genRegistry(cxt *context, w io.Writer, fset *token.FileSet) error {
+  if !refRegistry {
+    return nil
+  }
+  
+  fields, err := listPackage(cxt, fset)
+  if err != nil {
+    return err
+  }
+  
+  var names []string
+  byStruct := make(map[string][]string)
+  for _, e := range fields {
+    if _, ok := byStruct[e.Struct]; !ok {
+      names = append(names, e.Struct)
+    }
+    byStruct[e.Struct] = append(byStruct[e.Struct], fmt.Sprintf("{Name:%q, Type:%q, Referenced:%q, IdKey:%q, ValueKey:%q, Variant:%q, OmitEmpty:%v},", e.Field, "*"+ e.Ref, e.Type, e.IdKey, e.ValueKey, e.Marshal, e.OmitEmpty))
+  }
+  
+  var entries, structs string
+  for _, e := range names {
+    entries += fmt.Sprintf("\n  %q: {\n    %s\n  },", e, strings.Join(byStruct[e], "\n    "))
+    structs += fmt.Sprintf("%q, ", e)
+  }
+  
+  rspec := fmt.Sprintf(`
+// %[1]v describes a ref field of a struct in this package, as its tags
+// declare it
+type %[1]v struct {
+  Name       string // the field, by its path for fields of anonymous structs
+  Type       string // the Go type of the field
+  Referenced string // the type it refers to
+  IdKey      string // the JSON key of its id
+  ValueKey   string // the JSON key of its value
+  Variant    string // which of these it's marshaled as: id or value
+  OmitEmpty  bool
+}
+
+var %[4]v = map[string][]%[1]v{%[5]v
+}
+
+// %[2]v returns the ref fields of a struct in this package, by its name, in
+// the order they are declared. It returns nothing for a struct without any.
+func %[2]v(name string) []%[1]v {
+  return append([]%[1]v(nil), %[4]v[name]...)
+}
+
+// %[3]v returns the names of the structs in this package that have ref
+// fields, in the order they are declared
+func %[3]v() []string {
+  return []string{%[6]v}
+}`,
+  registryField, registryFieldsOf, registryStructs, registryVar, entries, strings.TrimSuffix(structs, ", "))
+  
+  fmt.Fprint(w, "\n"+ strings.TrimSpace(rspec) +"\n")
+  return nil
+}
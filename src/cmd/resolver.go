@@ -0,0 +1,290 @@
+package main
+
+import (
+  "io"
+  "fmt"
+  "strings"
+  "reflect"
+  "go/ast"
+  "reftag"
+)
+
+/**
+ * The struct tag that names the column a field is stored in
+ */
+const dbTag = "db"
+
+/**
+ * The interface a resolver queries through; it's satisfied by *sql.DB,
+ * *sql.Tx and *sql.Conn
+ */
+const resolverQuerier = `interface {
+  QueryContext(ref_context.Context, string, ...interface{}) (*ref_sql.Rows, error)
+}`
+
+/**
+ * A column a struct field is stored in
+ */
+type sqlColumn struct {
+  Name  string
+  Field string
+}
+
+/**
+ * Record what a ref tag maps the referenced type to for its resolver, e.g.,
+ * a table. Every tag that maps the same type must agree.
+ */
+func addMapping(m map[string]string, id *ident, v, what string) error {
+  if v == "" {
+    return nil
+  }
+  n := strings.TrimLeft(id.Name, "*")
+  if c, ok := m[n]; ok && c != v {
+    return fmt.Errorf("Conflicting %s for %v: %v, %v", what, n, c, v)
+  }
+  m[n] = v
+  return nil
+}
+
+/**
+ * Find the struct a ref type refers to, which is declared in the package or
+ * one it imports. A resolver needs its columns, so only these can have one.
+ * The name returned is how the package refers to it.
+ */
+func resolverStruct(cxt *context, id *ident) (string, *ast.StructType, bool, error) {
+  if id.Dims > 0 || id.Key != nil || id.Inds > 1 {
+    return "", nil, false, nil
+  }
+  n := strings.TrimLeft(id.Name, "*")
+  
+  decl, t := cxt, n
+  if x := strings.Index(n, "."); x > -1 {
+    imp, ok := cxt.Imports[n[:x]]
+    if !ok {
+      imp, ok = cxt.Deps[n[:x]]
+    }
+    if !ok {
+      return "", nil, false, nil
+    }
+    p := importPath(imp)
+    ext, ok := cxt.Loaded[p]
+    if !ok {
+      var err error
+      ext, err = loadPackage(cxt, p)
+      if err != nil {
+        return "", nil, false, err
+      }
+      cxt.Loaded[p] = ext
+    }
+    if ext == nil {
+      return "", nil, false, nil
+    }
+    decl, t = ext, n[x+1:]
+  }
+  
+  spec, ok := decl.Types[t]
+  if !ok || spec.TypeParams != nil {
+    return "", nil, false, nil
+  }
+  s, ok := spec.Type.(*ast.StructType)
+  return n, s, ok, nil
+}
+
+/**
+ * Map the fields of a struct to columns. Columns are named by the db tag or
+ * else the lowercased field name; the Id or ID field is the key.
+ */
+func structColumns(n string, s *ast.StructType) (sqlColumn, []sqlColumn, error) {
+  var key sqlColumn
+  var cols []sqlColumn
+  if s.Fields != nil {
+    for _, e := range s.Fields.List {
+      if _, _, ok := anonStruct(e.Type); ok {
+        continue // not a column
+      }
+      var db string
+      if e.Tag != nil {
+        db = reflect.StructTag(stringLit(e.Tag)).Get(dbTag)
+      }
+      for _, v := range e.Names {
+        if !ast.IsExported(v.Name) || db == "-" {
+          continue
+        }
+        c, _ := reftag.Split(db)
+        if c == "" {
+          c = strings.ToLower(v.Name)
+        }
+        if v.Name == "Id" || v.Name == "ID" {
+          key = sqlColumn{c, v.Name}
+        }
+        cols = append(cols, sqlColumn{c, v.Name})
+      }
+    }
+  }
+  if key.Name == "" {
+    return sqlColumn{}, nil, fmt.Errorf("SQL resolver for %v needs an Id or ID field for the key column", n)
+  }
+  return key, cols, nil
+}
+
+/**
+ * Generate the SQL resolver for a ref type, if its referenced type is mapped
+ * to a table by a ref tag or the configuration
+ */
+func genResolver(cxt *context, w io.Writer, ref *refType) error {
+  n := strings.TrimLeft(ref.Ident.Name, "*")
+  table, tagged := cxt.Tables[n]
+  if !tagged {
+    table = sqlTables[n]
+  }
+  if table == "" {
+    return nil
+  }
+  
+  t, s, ok, err := resolverStruct(cxt, ref.Ident)
+  if err != nil {
+    return err
+  }
+  if !ok {
+    if tagged {
+      return fmt.Errorf("SQL resolver for %v requires a struct declared in the package or one it imports", n)
+    }
+    return nil // the configuration applies to every package
+  }
+  key, cols, err := structColumns(t, s)
+  if err != nil {
+    return err
+  }
+  
+  var names, dest []string
+  for _, e := range cols {
+    names = append(names, e.Name)
+    dest = append(dest, "&v."+ e.Field)
+  }
+  query := fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (", strings.Join(names, ", "), table, key.Name)
+  
+  refId := ref.Name
+  rspec := fmt.Sprintf(`
+// %[1]v%[6]v loads the %[3]vs that %[1]vs refer to from the %[4]v table.
+// Numbered placeholders ($1, $2, ...) are used instead of ? if Numbered is
+// set, and no more than BatchSize ids are queried at once unless it is zero.
+type %[1]v%[6]v struct {
+  DB        %[7]v
+  Numbered  bool
+  BatchSize int
+}
+
+func New%[1]v%[6]v(db %[7]v) *%[1]v%[6]v {
+  return &%[1]v%[6]v{DB:db}
+}
+
+// Resolve loads the value of every ref that has none, querying each distinct
+// id once. Refs that share an id share the value.
+func (r *%[1]v%[6]v) Resolve(ctx ref_context.Context, refs ...*%[1]v) error {
+  byId := make(map[%[2]v][]*%[1]v)
+  var ids []interface{}
+  for _, e := range refs {
+    if e == nil || e.Value != nil {
+      continue
+    }
+    if _, ok := byId[e.Id]; !ok {
+      ids = append(ids, e.Id)
+    }
+    byId[e.Id] = append(byId[e.Id], e)
+  }
+  
+  n := r.BatchSize
+  if n < 1 {
+    n = len(ids)
+  }
+  for len(ids) > 0 {
+    b := ids
+    if len(b) > n {
+      b = b[:n]
+    }
+    ids = ids[len(b):]
+    err := r.query(ctx, b, byId)
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func (r *%[1]v%[6]v) query(ctx ref_context.Context, ids []interface{}, refs map[%[2]v][]*%[1]v) error {
+  p := make([]string, len(ids))
+  for i := range ids {
+    if r.Numbered {
+      p[i] = ref_fmt.Sprintf("$%%d", i + 1)
+    }else{
+      p[i] = "?"
+    }
+  }
+  
+  rows, err := r.DB.QueryContext(ctx, %[5]q + ref_strings.Join(p, ", ") +")", ids...)
+  if err != nil {
+    return err
+  }
+  defer rows.Close()
+  for rows.Next() {
+    v := &%[3]v{}
+    err := rows.Scan(%[8]v)
+    if err != nil {
+      return err
+    }
+    
+    // the key column is selected once, into its field, and the id taken
+    // from there the way a column would be scanned into it
+    var id %[2]v
+    if x := ref_reflect.ValueOf(v.%[9]v); x.Type().AssignableTo(ref_reflect.TypeOf(id)) {
+      id = x.Interface().(%[2]v)
+    }else{
+      k := &%[1]v{}
+      err = k.Scan(x.Interface())
+      if err != nil {
+        return err
+      }
+      id = k.Id
+    }
+    for _, e := range refs[id] {
+      e.Value = v
+    }
+  }
+  return rows.Err()
+}`,
+  refId, idType, t, table, query, resolverSuffix, resolverQuerier, strings.Join(dest, ", "), key.Field)
+  
+  if lazyRefs {
+    rspec += resolverLoader(refId, resolverSuffix, t, "row", "sql.ErrNoRows", fmt.Sprintf("ref_fmt.Errorf(\"No %v with id %%v: %%w\", id, ref_sql.ErrNoRows)", t))
+  }
+  
+  fmt.Fprint(w, "\n"+ strings.TrimSpace(rspec) +"\n")
+  return nil
+}
+
+/**
+ * Generate the method that adapts a resolver to a lazy ref's loader, which
+ * loads one id at a time. Resolvers differ only in how they report an id
+ * with no value, which the doc describes with what and how and the code
+ * returns as the error expression err.
+ */
+func resolverLoader(refId, suffix, vtype, what, how, err string) string {
+  return fmt.Sprintf(`
+  
+// Loader adapts the resolver to load a single %[1]v. A missing %[5]v is
+// reported as %[6]v.
+func (r *%[1]v%[3]v) Loader() %[1]v%[4]v {
+  return func(ctx ref_context.Context, id %[2]v) (*%[7]v, error) {
+    e := &%[1]v{Id:id}
+    err := r.Resolve(ctx, e)
+    if err != nil {
+      return nil, err
+    }
+    if e.Value == nil {
+      return nil, %[8]v
+    }
+    return e.Value, nil
+  }
+}`,
+  refId, idType, suffix, loaderSuffix, what, how, vtype, err)
+}
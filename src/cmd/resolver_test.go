@@ -0,0 +1,70 @@
+package main
+
+import (
+  "os"
+  "os/exec"
+  "fmt"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestResolverImportedStruct(t *testing.T) {
+  dir := writeModule(t, map[string]map[string]string{
+    "orgs": {"orgs.go": `package orgs
+    
+type Org struct {
+  ID      string            `+"`json:\"id\" db:\"id\"`"+`
+  Name    string            `+"`json:\"name\" db:\"org_name\"`"+`
+  secret  string
+}
+`},
+    "a": {"a.go": `//go:build ignore
+    
+package a
+
+import "example.com/app/orgs"
+
+type Post struct {
+  Owner   *orgs.Org         `+"`json:\"owner\" ref:\"owner_id,table=orgs\"`"+`
+}
+`},
+  })
+  FORCE = true
+  
+  err := procDir(filepath.Join(dir, "a"), optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  data, err := os.ReadFile(filepath.Join(dir, "a", pkgSrc + fileSuffix +".go"))
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  // the columns come from the struct in the package it's declared in
+  assert.Contains(t, string(data), `"SELECT id, org_name FROM orgs WHERE id IN ("`)
+  assert.Contains(t, string(data), "v := &orgs.Org{}")
+  
+  cmd := exec.Command("go", "build", "./...")
+  cmd.Dir = dir
+  out, err := cmd.CombinedOutput()
+  assert.Nil(t, err, "The generated module doesn't build: %s", out)
+}
+
+func TestResolverMissingStruct(t *testing.T) {
+  dir := writeModule(t, map[string]map[string]string{
+    "a": {"a.go": `//go:build ignore
+    
+package a
+
+import "example.com/app/gone"
+
+type Post struct {
+  Owner   *gone.Org         `+"`json:\"owner\" ref:\"owner_id,table=orgs\"`"+`
+}
+`},
+  })
+  
+  err := procDir(filepath.Join(dir, "a"), optionNone)
+  assert.EqualError(t, err, "SQL resolver for gone.Org requires a struct declared in the package or one it imports")
+}
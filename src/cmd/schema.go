@@ -0,0 +1,177 @@
+package main
+
+import (
+  "path"
+  "sort"
+  "go/token"
+  "encoding/json"
+)
+
+/**
+ * The JSON Schema dialect documents are written in
+ */
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+/**
+ * Generated schema files are named for their struct with this suffix
+ */
+const schemaSuffix = ".schema.json"
+
+/**
+ * A JSON Schema, as its keywords
+ */
+type schema map[string]interface{}
+
+/**
+ * Produce the schema for a wire type. Definitions are referred to in $defs.
+ */
+func schemaOf(t *wireType) schema {
+  var s schema
+  switch t.Kind {
+    case wireBool:
+      s = schema{"type":"boolean"}
+    case wireInteger:
+      s = schema{"type":"integer"}
+    case wireNumber:
+      s = schema{"type":"number"}
+    case wireString:
+      s = schema{"type":"string"}
+    case wireBytes:
+      s = schema{"type":"string", "contentEncoding":"base64"}
+    case wireTime:
+      s = schema{"type":"string", "format":"date-time"}
+    case wireArray:
+      s = schema{"type":"array", "items":schemaOf(t.Elem)}
+    case wireMap:
+      s = schema{"type":"object", "additionalProperties":schemaOf(t.Elem)}
+    case wireObject:
+      s = schemaObject(t)
+    case wireNamed:
+      s = schema{"$ref":"#/$defs/"+ t.Name}
+    default:
+      return schema{} // anything
+  }
+  if t.Nullable {
+    if v, ok := s["type"].(string); ok {
+      s["type"] = []string{v, "null"}
+    }else{
+      s = schema{"anyOf":[]schema{s, schema{"type":"null"}}}
+    }
+  }
+  return s
+}
+
+/**
+ * Produce the schema for an object. A field is required unless it's omitted
+ * when empty. A ref field has a property for its id and one for its value,
+ * which may not both be present, or one property for either. It is never
+ * required, since a nil ref is marshaled as neither.
+ */
+func schemaObject(t *wireType) schema {
+  props := schema{}
+  required := []string{}
+  var cond []schema
+  for _, e := range t.Fields {
+    if !e.Ref {
+      props[e.Key] = schemaOf(e.Type)
+      if !e.OmitEmpty {
+        required = append(required, e.Key)
+      }
+      continue
+    }
+    
+    if e.IdKey == e.Key {
+      props[e.Key] = schema{"anyOf":[]schema{schemaOf(e.Id), schemaOf(e.Type)}}
+      continue
+    }
+    props[e.IdKey] = schemaOf(e.Id)
+    props[e.Key] = schemaOf(e.Type)
+    cond = append(cond, schema{"not":schema{"required":[]string{e.IdKey, e.Key}}})
+  }
+  
+  s := schema{"type":"object", "properties":props}
+  if len(required) > 0 {
+    s["required"] = required
+  }
+  if len(cond) > 0 {
+    s["allOf"] = cond
+  }
+  return s
+}
+
+/**
+ * Collect the names of the definitions a wire type refers to, directly or
+ * through other definitions
+ */
+func (m *wireModel) reach(t *wireType, seen map[string]bool) {
+  switch t.Kind {
+    case wireArray, wireMap:
+      m.reach(t.Elem, seen)
+    case wireObject:
+      for _, e := range t.Fields {
+        m.reach(e.Type, seen)
+        if e.Id != nil {
+          m.reach(e.Id, seen)
+        }
+      }
+    case wireNamed:
+      if !seen[t.Name] {
+        seen[t.Name] = true
+        m.reach(m.Types[t.Name], seen)
+      }
+  }
+}
+
+/**
+ * Produce the schema document for a struct in a wire model, which defines the
+ * struct and every type it refers to in $defs
+ */
+func (m *wireModel) Schema(root string) schema {
+  seen := make(map[string]bool)
+  m.reach(&wireType{Kind:wireNamed, Name:root}, seen)
+  
+  var names []string
+  for k := range seen {
+    names = append(names, k)
+  }
+  sort.Strings(names)
+  
+  defs := schema{}
+  for _, e := range names {
+    defs[e] = schemaOf(m.Types[e])
+  }
+  return schema{
+    "$schema": schemaDialect,
+    "title":   root,
+    "$ref":    "#/$defs/"+ root,
+    "$defs":   defs,
+  }
+}
+
+/**
+ * Write a JSON Schema document for every struct with ref fields in the
+ * packages in a directory, to the directory or another one
+ */
+func writeSchemas(dir, out string, opts options) error {
+  if out == "" {
+    out = dir
+  }
+  return inspectDir(dir, opts, func(cxt *context, fset *token.FileSet) error {
+    m, err := buildWireModel(cxt)
+    if err != nil {
+      return err
+    }
+    var outs outputSet
+    for _, e := range m.Roots {
+      data, err := json.MarshalIndent(m.Schema(e), "", "  ")
+      if err != nil {
+        return err
+      }
+      err = outs.Add(path.Join(out, e + schemaSuffix), e + schemaSuffix, append(data, '\n'))
+      if err != nil {
+        return err
+      }
+    }
+    return outs.Commit()
+  })
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "bytes"
+  "strings"
+  "testing"
+  "os/exec"
+  "path/filepath"
+  "encoding/json"
+  "github.com/stretchr/testify/assert"
+  "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+func TestWriteSchemas(t *testing.T) {
+  dir := t.TempDir()
+  err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(`package a
+  
+import "time"
+
+type User struct {
+  Id      int64             `+"`json:\"id\"`"+`
+  Created time.Time         `+"`json:\"created\"`"+`
+  Avatar  []byte            `+"`json:\"avatar,omitempty\"`"+`
+}
+
+type Post struct {
+  Title   string            `+"`json:\"title\"`"+`
+  Author  *User             `+"`json:\"author\" ref:\"author_id\"`"+`
+  Editors []User            `+"`json:\"editors,omitempty\" ref:\"editor_ids,value\"`"+`
+}
+`), 0644)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  defer func(v string) { idType = v }(idType)
+  idType = "int64"
+  err = writeSchemas(dir, "", optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  data, err := os.ReadFile(filepath.Join(dir, "Post"+ schemaSuffix))
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  var doc map[string]interface{}
+  err = json.Unmarshal(data, &doc)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  var expect map[string]interface{}
+  err = json.Unmarshal([]byte(`{
+    "$schema": "https://json-schema.org/draft/2020-12/schema",
+    "title": "Post",
+    "$ref": "#/$defs/Post",
+    "$defs": {
+      "Post": {
+        "type": "object",
+        "properties": {
+          "title":      {"type": "string"},
+          "author_id":  {"type": "integer"},
+          "author":     {"$ref": "#/$defs/User"},
+          "editor_ids": {"type": "integer"},
+          "editors":    {"type": "array", "items": {"$ref": "#/$defs/User"}}
+        },
+        "required": ["title"],
+        "allOf": [
+          {"not": {"required": ["author_id", "author"]}},
+          {"not": {"required": ["editor_ids", "editors"]}}
+        ]
+      },
+      "User": {
+        "type": "object",
+        "properties": {
+          "id":       {"type": "integer"},
+          "created":  {"type": "string", "format": "date-time"},
+          "avatar":   {"type": "string", "contentEncoding": "base64"}
+        },
+        "required": ["id", "created"]
+      }
+    }
+  }`), &expect)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, expect, doc)
+  }
+  
+  _, err = os.Stat(filepath.Join(dir, "User"+ schemaSuffix))
+  assert.True(t, os.IsNotExist(err), "Only structs with ref fields have documents")
+}
+
+func TestSchemaIdTypes(t *testing.T) {
+  dir := t.TempDir()
+  err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(`package a
+  
+import "fmt"
+
+type UUID [16]byte
+
+func (u UUID) MarshalText() ([]byte, error) {
+  return []byte(fmt.Sprintf("%x", u[:])), nil
+}
+
+type User struct {
+  Id      UUID              `+"`json:\"id\"`"+`
+}
+
+type Post struct {
+  Author  *User             `+"`json:\"author\" ref:\"author_id\"`"+`
+}
+`), 0644)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  defer func(v string) { idType = v }(idType)
+  idType = "UUID"
+  err = writeSchemas(dir, "", optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  doc := readSchema(t, filepath.Join(dir, "Post"+ schemaSuffix))
+  if doc == nil {
+    return
+  }
+  
+  // a type that marshals itself as text is a string, not anything
+  defs := doc["$defs"].(map[string]interface{})
+  assert.Equal(t, map[string]interface{}{"$ref":"#/$defs/UUID"}, defs["Post"].(map[string]interface{})["properties"].(map[string]interface{})["author_id"])
+  assert.Equal(t, map[string]interface{}{"type":"string"}, defs["UUID"])
+}
+
+/**
+ * The sources of a module whose ids are a type in another package, and a
+ * program that prints posts marshaled as JSON, one per line
+ */
+var schemaModule = map[string]map[string]string{
+  "ids": {"ids.go": `package ids
+  
+import "fmt"
+
+type UUID [16]byte
+
+func (u UUID) MarshalText() ([]byte, error) {
+  return []byte(fmt.Sprintf("%x", u[:])), nil
+}
+`},
+  "blog": {"blog.go": `//go:build ignore
+  
+package blog
+
+import (
+  "time"
+  "example.com/app/ids"
+)
+
+type User struct {
+  Id      ids.UUID          `+"`json:\"id\"`"+`
+  Created time.Time         `+"`json:\"created\"`"+`
+  Avatar  []byte            `+"`json:\"avatar,omitempty\"`"+`
+}
+
+type Post struct {
+  Title   string            `+"`json:\"title\"`"+`
+  Author  *User             `+"`json:\"author\" ref:\"author_id\"`"+`
+  Editors []User            `+"`json:\"editors,omitempty\" ref:\"editor_ids,value\"`"+`
+}
+`},
+  "dump": {"main.go": `package main
+  
+import (
+  "fmt"
+  "encoding/json"
+  "example.com/app/ids"
+  "example.com/app/blog"
+)
+
+func main() {
+  u := blog.User{Id:ids.UUID{1}, Avatar:[]byte("a")}
+  for _, e := range []blog.Post{
+    {Title:"none"},
+    {Title:"id", Author:blog.NewUserRefId(ids.UUID{2})},
+    {Title:"value", Author:blog.NewUserRef(&u), Editors:blog.NewArrayOfUserRef([]blog.User{u})},
+    {Title:"ids", Editors:blog.NewArrayOfUserRefId(ids.UUID{3})},
+  } {
+    data, err := json.Marshal(e)
+    if err != nil {
+      panic(err)
+    }
+    fmt.Println(string(data))
+  }
+}
+`},
+}
+
+/**
+ * Read a schema document
+ */
+func readSchema(t *testing.T, p string) map[string]interface{} {
+  data, err := os.ReadFile(p)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return nil
+  }
+  var doc map[string]interface{}
+  err = json.Unmarshal(data, &doc)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return nil
+  }
+  return doc
+}
+
+func TestSchemaValidatesMarshaled(t *testing.T) {
+  dir := writeModule(t, schemaModule)
+  applySettings(t, settings{Ident:stringPtr("ids.UUID"), Imports:[]string{"example.com/app/ids"}})
+  FORCE = true
+  
+  blog := filepath.Join(dir, "blog")
+  err := procDir(blog, optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  err = writeSchemas(blog, "", optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  // ids are modeled from the package that declares their type
+  doc := readSchema(t, filepath.Join(blog, "Post"+ schemaSuffix))
+  if doc == nil {
+    return
+  }
+  defs := doc["$defs"].(map[string]interface{})
+  assert.Equal(t, map[string]interface{}{"$ref":"#/$defs/ids.UUID"}, defs["Post"].(map[string]interface{})["properties"].(map[string]interface{})["author_id"])
+  assert.Equal(t, map[string]interface{}{"type":"string"}, defs["ids.UUID"])
+  
+  c := jsonschema.NewCompiler()
+  sch, err := c.Compile(filepath.Join(blog, "Post"+ schemaSuffix))
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  cmd := exec.Command("go", "run", "./dump")
+  cmd.Dir = dir
+  var stderr bytes.Buffer
+  cmd.Stderr = &stderr
+  out, err := cmd.Output()
+  if !assert.Nil(t, err, "The posts could not be marshaled: %s", stderr.String()) {
+    return
+  }
+  lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+  assert.Len(t, lines, 4)
+  for _, e := range lines {
+    var v interface{}
+    err := json.Unmarshal([]byte(e), &v)
+    if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+      assert.Nil(t, sch.Validate(v), "Marshaled post is not valid: %s", e)
+    }
+  }
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "bytes"
+  "bufio"
+  "strings"
+  "strconv"
+  "go/ast"
+  "go/build"
+  "go/token"
+  "go/format"
+  "path/filepath"
+  "go/build/constraint"
+  "golang.org/x/tools/go/packages"
+)
+
+/**
+ * Shared ref types are generated once, into the package that owns them, and
+ * reused by every package that refers to the same type. The owner is the
+ * package that declares the referenced type or, if one is designated, a
+ * shared package. The file they're generated into records which packages
+ * use each ref type so it can be maintained as packages are regenerated.
+ */
+const (
+  sharedSrc       = "pkg_shared"
+  sharedDirective = "//goref:shared"
+  usesDirective   = "//goref:uses"
+  sharedImport    = "ref_shared"
+)
+
+func sharing() bool {
+  return sharedRefs || sharedPkg != ""
+}
+
+/**
+ * A shared ref type
+ */
+type sharedRef struct {
+  Name    string    // the generated type's name
+  Path    string    // the import path of the referenced type's package
+  Type    string    // the referenced type's name
+  Users   []string  // the import paths of packages that use it
+}
+
+/**
+ * The file shared ref types are generated into
+ */
+type sharedFile struct {
+  Path        string
+  Package     string
+  ImportPath  string
+  Refs        []*sharedRef
+}
+
+/**
+ * Determine the import path of the package in a directory
+ */
+func packageImportPath(dir string) (string, error) {
+  pkgs, err := packages.Load(&packages.Config{Mode:packages.NeedName, Dir:dir}, ".")
+  if err != nil {
+    return "", err
+  }
+  if len(pkgs) < 1 || pkgs[0].PkgPath == "" || pkgs[0].PkgPath == "." {
+    return "", fmt.Errorf("Cannot determine the import path of the package in: %v", dir)
+  }
+  return pkgs[0].PkgPath, nil
+}
+
+/**
+ * Determine if a directory is one we shouldn't write to: the standard
+ * library or the module cache
+ */
+func isReadOnlyPackage(pkg *build.Package) bool {
+  if pkg.Goroot {
+    return true
+  }
+  for _, e := range filepath.SplitList(build.Default.GOPATH) {
+    if strings.HasPrefix(pkg.Dir, filepath.Join(e, "pkg", "mod") + string(filepath.Separator)) {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Prepare a package for sharing ref types. Packages it used the last time
+ * it was generated are loaded and it's released from them; whatever it
+ * still uses is claimed again as it's processed.
+ */
+func prepareShared(cxt *context, dir string) error {
+  var err error
+  cxt.Dir = dir
+  cxt.ImportPath, err = packageImportPath(dir)
+  if err != nil {
+    return err
+  }
+  
+  f, err := os.Open(filepath.Join(dir, pkgSrc + fileSuffix +".go"))
+  if os.IsNotExist(err) {
+    return nil
+  }else if err != nil {
+    return err
+  }
+  defer f.Close()
+  
+  scan := bufio.NewScanner(f)
+  for scan.Scan() {
+    l := strings.TrimSpace(scan.Text())
+    if l != "" && !strings.HasPrefix(l, "//") {
+      break // the directives are in the header
+    }
+    if c, t := args(l); c == usesDirective {
+      s, err := sharedOwner(cxt, t)
+      if err != nil {
+        return err
+      }
+      if s != nil {
+        s.Release(cxt.ImportPath)
+      }
+    }
+  }
+  return scan.Err()
+}
+
+/**
+ * Obtain the shared ref types owned by a package, loading them if needed.
+ * If the package can't own shared ref types nil is returned.
+ */
+func sharedOwner(cxt *context, owner string) (*sharedFile, error) {
+  if s, ok := cxt.Shared[owner]; ok {
+    return s, nil
+  }
+  
+  // resolve the owner from the package's own module, not wherever we're run
+  var err error
+  bcxt := build.Default
+  bcxt.Dir, err = filepath.Abs(cxt.Dir)
+  if err != nil {
+    return nil, err
+  }
+  pkg, err := bcxt.Import(owner, bcxt.Dir, 0)
+  if err != nil {
+    if _, ok := err.(*build.NoGoError); !ok {
+      return nil, fmt.Errorf("Cannot find the package that owns shared ref types: %v", err)
+    }
+  }
+  if isReadOnlyPackage(pkg) {
+    if owner == sharedPkg {
+      return nil, fmt.Errorf("Shared package is not writable: %v", owner)
+    }
+    return nil, nil
+  }
+  
+  s := &sharedFile{Path:filepath.Join(pkg.Dir, sharedSrc + fileSuffix +".go"), Package:pkg.Name, ImportPath:owner}
+  if s.Package == "" {
+    s.Package = assumedPackageName(owner)
+  }
+  err = s.Load()
+  if err != nil {
+    return nil, err
+  }
+  
+  cxt.Shared[owner] = s
+  return s, nil
+}
+
+/**
+ * Make sure a source's copy won't be written over the shared ref types. The
+ * owner's file is maintained by the packages that use it, which don't see
+ * the owner's own output, so this is checked for every package.
+ */
+func checkSharedName(src string) error {
+  if filepath.Base(refFile(src)) == sharedSrc + fileSuffix +".go" {
+    return fmt.Errorf("%v: its copy would be written over the shared ref types; rename it", src)
+  }
+  return nil
+}
+
+/**
+ * Load the shared ref types from the owner's file, if it exists
+ */
+func (s *sharedFile) Load() error {
+  src := filepath.Join(filepath.Dir(s.Path), sharedSrc +".go")
+  if _, err := os.Stat(src); err == nil {
+    return checkSharedName(src)
+  }
+  
+  data, err := os.ReadFile(s.Path)
+  if os.IsNotExist(err) {
+    return nil
+  }else if err != nil {
+    return err
+  }
+  gen, err := isFileGenerated(s.Path)
+  if err != nil {
+    return err
+  }else if !gen {
+    return fmt.Errorf("%v: file exists but was not generated; it would be overwritten with shared ref types", s.Path)
+  }
+  for _, l := range strings.Split(string(data), "\n") {
+    f := strings.Fields(l)
+    if len(f) < 3 || f[0] != sharedDirective {
+      continue
+    }
+    x := strings.LastIndex(f[2], ".")
+    if x < 1 {
+      return fmt.Errorf("%v: invalid shared ref type: %v", s.Path, l)
+    }
+    s.Refs = append(s.Refs, &sharedRef{Name:f[1], Path:f[2][:x], Type:f[2][x+1:], Users:f[3:]})
+  }
+  return nil
+}
+
+/**
+ * Record that a package uses the shared ref type for a type, returning the
+ * name of the ref type
+ */
+func (s *sharedFile) Use(user, p, t string) (string, error) {
+  for _, e := range s.Refs {
+    if e.Path == p && e.Type == t {
+      e.Users = appendUnique(e.Users, user)
+      return e.Name, nil
+    }
+  }
+  
+  names := []string{t + refSuffix, strings.Title(assumedPackageName(p)) + t + refSuffix}
+  for _, n := range names {
+    var taken bool
+    for _, e := range s.Refs {
+      if e.Name == n {
+        taken = true
+        break
+      }
+    }
+    if !taken {
+      s.Refs = append(s.Refs, &sharedRef{Name:n, Path:p, Type:t, Users:[]string{user}})
+      return n, nil
+    }
+  }
+  
+  return "", fmt.Errorf("Cannot name the shared ref type for %v.%v without a collision in: %v", p, t, s.ImportPath)
+}
+
+/**
+ * Release a package from every shared ref type it uses. Types nothing uses
+ * any longer are dropped.
+ */
+func (s *sharedFile) Release(user string) {
+  var refs []*sharedRef
+  for _, e := range s.Refs {
+    var users []string
+    for _, u := range e.Users {
+      if u != user {
+        users = append(users, u)
+      }
+    }
+    if len(users) > 0 {
+      e.Users = users
+      refs = append(refs, e)
+    }
+  }
+  s.Refs = refs
+}
+
+/**
+ * Determine if a package uses any of the shared ref types
+ */
+func (s *sharedFile) UsedBy(user string) bool {
+  for _, e := range s.Refs {
+    for _, u := range e.Users {
+      if u == user {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+/**
+ * Generate the owner's file. If no shared ref types are left the file is
+ * removed.
+ */
+func (s *sharedFile) Generate() (*output, error) {
+  if len(s.Refs) < 1 {
+    return &output{Path:s.Path, Name:s.Path, Remove:true}, nil
+  }
+  
+  refs := append([]*sharedRef(nil), s.Refs...)
+  sort.Slice(refs, func(i, j int) bool {
+    return refs[i].Name < refs[j].Name
+  })
+  
+  cxt := newContext(s.Package, nil, optionNone)
+  body := &bytes.Buffer{}
+  for _, e := range refs {
+    id := newIdent(e.Type, e.Type, 0, 0)
+    if e.Path != s.ImportPath {
+      m := &ast.ImportSpec{Path:&ast.BasicLit{Kind:token.STRING, Value:strconv.Quote(e.Path)}}
+      cxt.Deps.Add(m)
+      id = newIdent(importPackage(m) +"."+ e.Type, e.Type, 0, 0)
+    }
+    sort.Strings(e.Users)
+    fmt.Fprintf(body, "\n%s %s %s.%s %s\n", sharedDirective, e.Name, e.Path, e.Type, strings.Join(e.Users, " "))
+    err := genType(cxt, body, nil, &refType{Ident:id, Name:e.Name})
+    if err != nil {
+      return nil, err
+    }
+  }
+  
+  imports, err := generatedImports(cxt, body.Bytes())
+  if err != nil {
+    return nil, fmt.Errorf("%v: %v", s.Path, err)
+  }
+  
+  gen := &bytes.Buffer{}
+  if constraintTag != "" {
+    fmt.Fprintf(gen, "%s\n\n", constraintLine(&constraint.TagExpr{Tag:constraintTag}))
+  }else if buildTag != "" {
+    fmt.Fprintf(gen, "// %s\n\n", buildTag)
+  }
+  fmt.Fprintf(gen, "// %s. Changes will be overwritten.\n// Ref types shared with other packages\npackage %v\n", generatedHeader, s.Package)
+  if imports != "" {
+    fmt.Fprint(gen, "\n"+ imports)
+  }
+  gen.Write(body.Bytes())
+  
+  data, err := format.Source(gen.Bytes())
+  if err != nil {
+    return nil, fmt.Errorf("%v: generated code is invalid: %v", s.Path, err)
+  }
+  return &output{Path:s.Path, Name:s.Path, Data:data}, nil
+}
+
+/**
+ * The owners of shared ref types a package has loaded, ordered by import
+ * path
+ */
+func sharedOwners(cxt *context) []string {
+  owners := make([]string, 0, len(cxt.Shared))
+  for k := range cxt.Shared {
+    owners = append(owners, k)
+  }
+  sort.Strings(owners)
+  return owners
+}
+
+/**
+ * Determine if the ref type for a field can be shared and, if so, produce
+ * the type the field should refer to it by. Only refs to named types are
+ * shared; refs to slices and maps and refs with pinned names are not.
+ */
+func sharedRefType(cxt *context, id *ident, pinned string) (ast.Expr, bool, error) {
+  if !sharing() || pinned != "" || id.Dims > 0 || id.Key != nil || id.Inds > 1 {
+    return nil, false, nil
+  }
+  
+  var qual, t, p string
+  n := strings.TrimLeft(id.Name, "*")
+  if x := strings.LastIndex(n, "."); x > 0 {
+    qual, t = n[:x], n[x+1:]
+    if strings.Contains(qual, ".") {
+      return nil, false, nil
+    }
+    m, ok := cxt.Imports[qual]
+    if !ok {
+      return nil, false, nil // reported elsewhere
+    }
+    p = importPath(m)
+  }else if sharedPkg == "" {
+    t, p = n, cxt.ImportPath // we're the owner
+  }else{
+    return nil, false, nil // the shared package can't import us
+  }
+  
+  owner := p
+  if sharedPkg != "" {
+    owner = sharedPkg
+  }
+  s, err := sharedOwner(cxt, owner)
+  if err != nil || s == nil {
+    return nil, false, err
+  }
+  name, err := s.Use(cxt.ImportPath, p, t)
+  if err != nil {
+    return nil, false, err
+  }
+  
+  var x ast.Expr
+  var key string
+  switch {
+    case owner == cxt.ImportPath:
+      x, key = ast.NewIdent(name), name
+      cxt.Decls[name] = struct{}{} // so nothing local is generated by this name
+    case sharedPkg != "":
+      x, key = &ast.SelectorExpr{X:ast.NewIdent(sharedImport), Sel:ast.NewIdent(name)}, sharedImport +"."+ name
+      cxt.Deps.Add(namedImport(sharedImport, sharedPkg))
+    default:
+      x, key = &ast.SelectorExpr{X:ast.NewIdent(qual), Sel:ast.NewIdent(name)}, qual +"."+ name
+  }
+  cxt.Lookup[key] = id
+  return x, true, nil
+}
+
+/**
+ * Split the type of a rewritten ref field into the qualifier of the package
+ * its ref type is declared in (including the dot; empty if it's declared in
+ * this package) and the ref type's name
+ */
+func refTypeName(ftype *ident) (string, string) {
+  n := strings.TrimLeft(ftype.Name, "*")
+  if x := strings.LastIndex(n, "."); x > 0 {
+    return n[:x+1], n[x+1:]
+  }
+  return "", n
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+  "os"
+  "os/exec"
+  "fmt"
+  "strings"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+const sharedUsers = "package users\n\ntype User struct {\n  Id string `json:\"id\"`\n}\n"
+
+/**
+ * A source declaring a type that refers to users.User, or doesn't if ref is
+ * false
+ */
+func sharedUser(pkg, name string, ref bool) string {
+  src := "//go:build ignore\n\npackage "+ pkg +"\n\n"
+  if ref {
+    return src +"import \"example.com/app/users\"\n\ntype "+ name +" struct {\n  Owner *users.User `json:\"owner\" ref:\"owner_id\"`\n}\n"
+  }
+  return src +"type "+ name +" struct {\n  Name string `json:\"name\"`\n}\n"
+}
+
+/**
+ * Write a module of packages, each a map of file names to sources
+ */
+func writeModule(t *testing.T, pkgs map[string]map[string]string) string {
+  dir := t.TempDir()
+  assert.Nil(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.21\n"), 0644))
+  for p, files := range pkgs {
+    assert.Nil(t, os.MkdirAll(filepath.Join(dir, p), 0755))
+    for k, v := range files {
+      assert.Nil(t, os.WriteFile(filepath.Join(dir, p, k), []byte(v), 0644))
+    }
+  }
+  return dir
+}
+
+/**
+ * The shared ref type directives in a file
+ */
+func sharedDirectives(t *testing.T, p string) []string {
+  data, err := os.ReadFile(p)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return nil
+  }
+  var d []string
+  for _, l := range strings.Split(string(data), "\n") {
+    if strings.HasPrefix(l, sharedDirective) || strings.HasPrefix(l, usesDirective) {
+      d = append(d, l)
+    }
+  }
+  return d
+}
+
+func TestShared(t *testing.T) {
+  dir := writeModule(t, map[string]map[string]string{
+    "users": {"users.go": sharedUsers},
+    "orgs":  {"orgs.go": sharedUser("orgs", "Org", true), "shared.go": sharedUser("orgs", "Shared", true)},
+    "teams": {"teams.go": sharedUser("teams", "Team", true)},
+  })
+  applySettings(t, settings{SharedRefs:boolPtr(true)})
+  FORCE = true
+  
+  owner := filepath.Join(dir, "users", sharedSrc + fileSuffix +".go")
+  for _, e := range []string{"orgs", "teams"} {
+    err := procDir(filepath.Join(dir, e), optionNone)
+    if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+      return
+    }
+  }
+  
+  // the ref type is generated once, in the package that declares the type
+  assert.Equal(t, []string{"//goref:shared UserRef example.com/app/users.User example.com/app/orgs example.com/app/teams"}, sharedDirectives(t, owner))
+  for _, e := range []string{"orgs", "teams"} {
+    assert.Equal(t, []string{"//goref:uses example.com/app/users"}, sharedDirectives(t, filepath.Join(dir, e, pkgSrc + fileSuffix +".go")), e)
+  }
+  // a source named like the shared file gets a copy of its own
+  _, err := os.Stat(filepath.Join(dir, "orgs", "shared"+ fileSuffix +".go"))
+  assert.Nil(t, err, fmt.Sprintf("%v", err))
+  
+  cmd := exec.Command("go", "build", "./...")
+  cmd.Dir = dir
+  out, err := cmd.CombinedOutput()
+  assert.Nil(t, err, "The generated module doesn't build: %s", out)
+  
+  // a package that no longer uses the ref type releases it
+  assert.Nil(t, os.WriteFile(filepath.Join(dir, "teams", "teams.go"), []byte(sharedUser("teams", "Team", false)), 0644))
+  err = procDir(filepath.Join(dir, "teams"), optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  assert.Equal(t, []string{"//goref:shared UserRef example.com/app/users.User example.com/app/orgs"}, sharedDirectives(t, owner))
+  
+  // and when nothing uses it the owner's file is removed
+  assert.Nil(t, os.WriteFile(filepath.Join(dir, "orgs", "orgs.go"), []byte(sharedUser("orgs", "Org", false)), 0644))
+  assert.Nil(t, os.WriteFile(filepath.Join(dir, "orgs", "shared.go"), []byte(sharedUser("orgs", "Shared", false)), 0644))
+  err = procDir(filepath.Join(dir, "orgs"), optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  _, err = os.Stat(owner)
+  assert.True(t, os.IsNotExist(err), fmt.Sprintf("%v", err))
+}
+
+func TestSharedNotGenerated(t *testing.T) {
+  dir := writeModule(t, map[string]map[string]string{
+    "users": {"users.go": sharedUsers, sharedSrc + fileSuffix +".go": "package users\n"},
+    "orgs":  {"orgs.go": sharedUser("orgs", "Org", true)},
+  })
+  applySettings(t, settings{SharedRefs:boolPtr(true)})
+  FORCE = true
+  
+  // the file is reported as it's needed and left alone
+  owner := filepath.Join(dir, "users", sharedSrc + fileSuffix +".go")
+  assert.Nil(t, procDir(filepath.Join(dir, "orgs"), optionNone))
+  data, err := os.ReadFile(owner)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, "package users\n", string(data))
+  }
+  _, err = os.Stat(filepath.Join(dir, "orgs", "orgs"+ fileSuffix +".go"))
+  assert.True(t, os.IsNotExist(err), fmt.Sprintf("%v", err))
+}
+
+func TestSharedSourceName(t *testing.T) {
+  dir := writeModule(t, map[string]map[string]string{
+    "users": {"users.go": sharedUsers, sharedSrc +".go": sharedUser("users", "Group", false)},
+    "orgs":  {"orgs.go": sharedUser("orgs", "Org", true)},
+  })
+  applySettings(t, settings{SharedRefs:boolPtr(true)})
+  FORCE = true
+  
+  // the owner's source is rejected when it's generated
+  err := procDir(filepath.Join(dir, "users"), optionNone)
+  assert.EqualError(t, err, filepath.Join(dir, "users", sharedSrc +".go") +": its copy would be written over the shared ref types; rename it")
+  
+  // and when a package using the owner's shared ref types is, it's reported
+  // and nothing is written
+  assert.Nil(t, procDir(filepath.Join(dir, "orgs"), optionNone))
+  for _, e := range []string{filepath.Join("users", sharedSrc + fileSuffix +".go"), filepath.Join("orgs", "orgs"+ fileSuffix +".go")} {
+    _, err = os.Stat(filepath.Join(dir, e))
+    assert.True(t, os.IsNotExist(err), e)
+  }
+}
+
+func TestOutputSetPaths(t *testing.T) {
+  var s outputSet
+  assert.Nil(t, s.Add("a/b_ref.go", "a/b_ref.go", nil))
+  assert.Nil(t, s.Add("a/c_ref.go", "a/c_ref.go", nil))
+  assert.EqualError(t, s.Append(&output{Path:"a/./b_ref.go"}), "a/./b_ref.go: more than one file would be generated here; rename the source file it's derived from")
+  assert.Len(t, s, 2)
+}
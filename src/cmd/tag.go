@@ -1,90 +1,38 @@
 package main
 
 import (
-  "fmt"
-  "strings"
   "strconv"
   "reflect"
   "go/ast"
   "go/token"
+  "reftag"
 )
 
-const (
-  refTag          = "ref"
-  jsonTag         = "json"
-  omitEmpty       = "omitempty"
-)
-
-const (
-  marshalIdTag    = "id"
-  marshalValueTag = "value"
-)
-
-type marshalVariant int
-const (
-  marshalId       = marshalVariant(iota)
-  marshalValue    = marshalVariant(iota)
-)
-
-type fieldNames struct {
-  Id, Value string
-}
-
-type marshalPolicy struct {
-  Names   fieldNames
-  Marshal marshalVariant
-  Ref, Omit, OmitEmpty bool
-}
-
-func fieldMarshalPolicy(field *ast.Field, id *ident) (marshalPolicy, error) {
-  var jtag, rtag, name, flags string
-  
-  if field.Tag != nil  && field.Tag.Kind == token.STRING {
-    tag, err := strconv.Unquote(field.Tag.Value)
-    if err != nil {
-      return marshalPolicy{}, err
-    }
-    t := reflect.StructTag(tag)
-    jtag = t.Get(jsonTag)
-    rtag = t.Get(refTag)
-  }
-  
-  if jtag == "-" || rtag == "-" {
-    return marshalPolicy{Omit:true}, nil
-  }else if jtag != "" && len(field.Names) > 1 {
-    return marshalPolicy{}, fmt.Errorf("Field list has %d identifiers for one tag", len(field.Names))
+/**
+ * Determine whether a field is tagged as a ref
+ */
+func isRefField(field *ast.Field) bool {
+  if field.Tag == nil || field.Tag.Kind != token.STRING {
+    return false
   }
-  
-  policy := marshalPolicy{}
-  
-  if jtag != "" {
-    name, flags = parseTag(jtag)
-  }else{
-    name = id.Base
+  tag, err := strconv.Unquote(field.Tag.Value)
+  if err != nil {
+    return false
   }
-  
-  policy.Names.Value = name
-  policy.OmitEmpty = policy.OmitEmpty || flags == omitEmpty
-  
-  if rtag != "" {
-    name, flags = parseTag(rtag)
-    policy.Ref  = true
-  }
-  
-  policy.Names.Id = name
-  if flags == marshalValueTag {
-    policy.Marshal = marshalValue
-  }else{
-    policy.Marshal = marshalId
-  }
-  
-  return policy, nil
+  return reflect.StructTag(tag).Get(reftag.Tag) != ""
 }
 
-func parseTag(t string) (string, string) {
-  if x := strings.Index(t, ","); x > 0 {
-    return t[:x], t[x+1:]
-  }else{
-    return t, ""
-  }
+/**
+ * Determine whether a struct has ref fields, including in the anonymous
+ * structs declared within it
+ */
+func hasRefFields(s *ast.StructType) bool {
+  var found bool
+  ast.Inspect(s, func(n ast.Node) bool {
+    if f, ok := n.(*ast.Field); ok && isRefField(f) {
+      found = true
+    }
+    return !found
+  })
+  return found
 }
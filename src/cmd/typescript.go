@@ -0,0 +1,169 @@
+package main
+
+import (
+  "fmt"
+  "path"
+  "strings"
+  "go/token"
+  "reftag"
+)
+
+/**
+ * Generated TypeScript definitions are named for their package with this
+ * suffix
+ */
+const typescriptSuffix = ".d.ts"
+
+/**
+ * Produce an object key, quoted if it isn't an identifier
+ */
+func tsKey(k string) string {
+  if token.IsIdentifier(k) {
+    return k
+  }
+  return fmt.Sprintf("%q", k)
+}
+
+/**
+ * Produce the TypeScript type for a wire type, indented for its depth in
+ * nested objects
+ */
+func tsType(t *wireType, depth int) string {
+  var s string
+  switch t.Kind {
+    case wireBool:
+      s = "boolean"
+    case wireInteger, wireNumber:
+      s = "number"
+    case wireString, wireBytes, wireTime:
+      s = "string"
+    case wireArray:
+      s = tsType(t.Elem, depth)
+      if strings.ContainsAny(s, "|&") {
+        s = "("+ s +")"
+      }
+      s += "[]"
+    case wireMap:
+      s = "{ [key: string]: "+ tsType(t.Elem, depth) +" }"
+    case wireObject:
+      s = tsObject(t, depth)
+    case wireNamed:
+      s = t.Name
+    default:
+      s = "unknown"
+  }
+  if t.Nullable {
+    s += " | null"
+  }
+  return s
+}
+
+/**
+ * Produce the TypeScript type for an object. A property is optional if it's
+ * omitted when empty. A ref field is either its id or its value, the one it's
+ * marshaled as first, and the object is intersected with each of these.
+ */
+func tsObject(t *wireType, depth int) string {
+  ind := strings.Repeat("  ", depth)
+  var props, refs []string
+  for _, e := range t.Fields {
+    if e.Ref && e.IdKey != e.Key {
+      id := fmt.Sprintf("{ %s?: %s }", tsKey(e.IdKey), tsType(e.Id, depth))
+      val := fmt.Sprintf("{ %s?: %s }", tsKey(e.Key), tsType(e.Type, depth))
+      if e.Marshal == reftag.Value {
+        id, val = val, id
+      }
+      refs = append(refs, "("+ id +" | "+ val +")")
+      continue
+    }
+    
+    opt, v := "", tsType(e.Type, depth + 1)
+    if e.Ref {
+      opt, v = "?", tsType(e.Id, depth + 1) +" | "+ v
+    }else if e.OmitEmpty {
+      opt = "?"
+    }
+    props = append(props, fmt.Sprintf("%s  %s%s: %s;\n", ind, tsKey(e.Key), opt, v))
+  }
+  
+  if len(props) == 0 && len(refs) > 0 {
+    return strings.Join(refs, " & ")
+  }
+  s := "{\n"+ strings.Join(props, "") + ind +"}"
+  if len(props) == 0 {
+    s = "{}"
+  }
+  if len(refs) > 0 {
+    s += " & "+ strings.Join(refs, " & ")
+  }
+  return s
+}
+
+/**
+ * Produce the TypeScript definition of a named type, indented for its depth
+ * in namespaces: an interface for an object without ref fields, and a type
+ * alias for anything else
+ */
+func tsDefinition(n string, t *wireType, depth int) string {
+  ind := strings.Repeat("  ", depth)
+  v := tsType(t, depth)
+  if t.Kind == wireObject && !t.Nullable && strings.HasPrefix(v, "{\n") && strings.HasSuffix(v, "}") {
+    return fmt.Sprintf("%sexport interface %s %s\n", ind, n, v)
+  }
+  return fmt.Sprintf("%sexport type %s = %s;\n", ind, n, v)
+}
+
+/**
+ * Produce the TypeScript definitions for a wire model, in the order its types
+ * were reached. Types declared in other packages are defined in a namespace
+ * for their package, and those that couldn't be found are noted.
+ */
+func (m *wireModel) TypeScript() string {
+  s := fmt.Sprintf("// %s. Changes will be overwritten.\n// Package %s\n", generatedHeader, m.Package)
+  for _, e := range m.Missing {
+    s += fmt.Sprintf("// %s could not be found, so it is unknown\n", e)
+  }
+  
+  var quals []string
+  nested := make(map[string][]string)
+  for _, e := range m.Names {
+    if i := strings.LastIndex(e, "."); i >= 0 {
+      q := e[:i]
+      if _, ok := nested[q]; !ok {
+        quals = append(quals, q)
+      }
+      nested[q] = append(nested[q], tsDefinition(e[i+1:], m.Types[e], 1))
+    }else{
+      s += "\n"+ tsDefinition(e, m.Types[e], 0)
+    }
+  }
+  for _, e := range quals {
+    s += fmt.Sprintf("\nexport namespace %s {\n%s}\n", e, strings.Join(nested[e], "\n"))
+  }
+  return s
+}
+
+/**
+ * Write TypeScript definitions for the structs with ref fields in the packages
+ * in a directory, and the types they refer to, to the directory or another one
+ */
+func writeTypeScript(dir, out string, opts options) error {
+  if out == "" {
+    out = dir
+  }
+  return inspectDir(dir, opts, func(cxt *context, fset *token.FileSet) error {
+    m, err := buildWireModel(cxt)
+    if err != nil {
+      return err
+    }
+    if len(m.Roots) == 0 {
+      return nil
+    }
+    var outs outputSet
+    err = outs.Add(path.Join(out, m.Package + typescriptSuffix), m.Package + typescriptSuffix, []byte(m.TypeScript()))
+    if err != nil {
+      return err
+    }
+    return outs.Commit()
+  })
+}
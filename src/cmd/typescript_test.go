@@ -0,0 +1,116 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestWriteTypeScript(t *testing.T) {
+  dir := t.TempDir()
+  err := os.WriteFile(filepath.Join(dir, "a.go"), []byte(`package a
+  
+type Status string
+
+type User struct {
+  Id      string            `+"`json:\"id\"`"+`
+  Status  Status            `+"`json:\"status\"`"+`
+  Labels  map[string]string `+"`json:\"labels,omitempty\"`"+`
+}
+
+type Post struct {
+  Title   string            `+"`json:\"title\"`"+`
+  Author  *User             `+"`json:\"author\" ref:\"author_id\"`"+`
+  Editors []*User           `+"`json:\"editors\" ref:\"editor_ids,value\"`"+`
+  Meta    struct {
+    Owner *User             `+"`json:\"owner\" ref:\"owner_id\"`"+`
+  }                         `+"`json:\"meta\"`"+`
+}
+`), 0644)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  out := t.TempDir()
+  err = writeTypeScript(dir, out, optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  data, err := os.ReadFile(filepath.Join(out, "a"+ typescriptSuffix))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, `// This file was generated by Go-Ref. Changes will be overwritten.
+// Package a
+
+export type Post = {
+  title: string;
+  meta: ({ owner_id?: string } | { owner?: User });
+} & ({ author_id?: string } | { author?: User }) & ({ editors?: (User | null)[] } | { editor_ids?: string });
+
+export interface User {
+  id: string;
+  status: Status;
+  labels?: { [key: string]: string };
+}
+
+export type Status = string;
+`, string(data))
+  }
+}
+
+func TestWriteTypeScriptPackages(t *testing.T) {
+  dir := writeModule(t, map[string]map[string]string{
+    "orgs": {"orgs.go": `package orgs
+    
+type Tag string
+
+type Org struct {
+  Id      string            `+"`json:\"id\"`"+`
+  Tags    []Tag             `+"`json:\"tags,omitempty\"`"+`
+  Parent  *Org              `+"`json:\"parent,omitempty\"`"+`
+}
+`},
+    "a": {"a.go": `package a
+    
+import (
+  "example.com/app/orgs"
+  "example.com/app/gone"
+)
+
+type Post struct {
+  Title   string            `+"`json:\"title\"`"+`
+  Owner   *orgs.Org         `+"`json:\"owner\" ref:\"owner_id\"`"+`
+  Other   gone.Thing        `+"`json:\"other\"`"+`
+}
+`},
+  })
+  
+  out := t.TempDir()
+  err := writeTypeScript(filepath.Join(dir, "a"), out, optionNone)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  data, err := os.ReadFile(filepath.Join(out, "a"+ typescriptSuffix))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, `// This file was generated by Go-Ref. Changes will be overwritten.
+// Package a
+// example.com/app/gone.Thing could not be found, so it is unknown
+
+export type Post = {
+  title: string;
+  other: unknown;
+} & ({ owner_id?: string } | { owner?: orgs.Org });
+
+export namespace orgs {
+  export interface Org {
+    id: string;
+    tags?: orgs.Tag[];
+    parent?: orgs.Org | null;
+  }
+
+  export type Tag = string;
+}
+`, string(data))
+  }
+}
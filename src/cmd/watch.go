@@ -0,0 +1,156 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "time"
+  "strings"
+  "syscall"
+  "os/signal"
+  "path/filepath"
+  "github.com/fsnotify/fsnotify"
+)
+
+/**
+ * How long to wait for a burst of saves to settle before regenerating
+ */
+const watchDebounce = 250 * time.Millisecond
+
+/**
+ * Watch the provided package directories and regenerate each one when its
+ * sources change. This runs until interrupted.
+ */
+func watch(dirs []string, cli settings, opts options) error {
+  w, err := fsnotify.NewWatcher()
+  if err != nil {
+    return err
+  }
+  defer w.Close()
+  
+  for _, e := range dirs {
+    err := w.Add(e)
+    if err != nil {
+      return fmt.Errorf("Could not watch: %v: %v", e, err)
+    }
+  }
+  
+  sig := make(chan os.Signal, 1)
+  signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+  defer signal.Stop(sig)
+  
+  // generate everything once up front so we start from a known state
+  regenerate(dirs, cli, opts)
+  fmt.Printf("%v: watching %d package(s); interrupt to stop\n", CMD, len(dirs))
+  
+  return watchEvents(w.Events, w.Errors, sig, cli, func(affected []string) {
+    regenerate(affected, cli, opts)
+  })
+}
+
+/**
+ * Collect the packages affected by filesystem events and regenerate them
+ * once the events settle, until stopped or the events end
+ */
+func watchEvents(events <-chan fsnotify.Event, errs <-chan error, stop <-chan os.Signal, cli settings, regen func([]string)) error {
+  pending := make(map[string]struct{})
+  var settle <-chan time.Time
+  for {
+    select {
+      
+      case <-stop:
+        fmt.Printf("%v: stopped watching\n", CMD)
+        return nil
+        
+      case err, ok := <-errs:
+        if !ok {
+          return nil
+        }
+        fmt.Printf("%v: watch: %v\n", CMD, err)
+        
+      case e, ok := <-events:
+        if !ok {
+          return nil
+        }
+        dir, ok := watchTarget(e, cli)
+        if !ok {
+          continue
+        }
+        if VERBOSE {
+          fmt.Printf("%v: changed: %v (%v)\n", CMD, e.Name, e.Op)
+        }
+        pending[dir] = struct{}{}
+        settle = time.After(watchDebounce) // restart the debounce window
+        
+      case <-settle:
+        settle = nil
+        affected := make([]string, 0, len(pending))
+        for e := range pending {
+          affected = append(affected, e)
+        }
+        pending = make(map[string]struct{})
+        sort.Strings(affected)
+        regen(affected)
+        
+    }
+  }
+}
+
+/**
+ * Determine which package directory, if any, a filesystem event affects.
+ * Only Go sources are considered and our own output is ignored, otherwise
+ * every run would trigger another one.
+ */
+func watchTarget(e fsnotify.Event, cli settings) (string, bool) {
+  if e.Op & (fsnotify.Write | fsnotify.Create | fsnotify.Remove | fsnotify.Rename) == 0 {
+    return "", false
+  }
+  
+  base := filepath.Base(e.Name)
+  if !strings.HasSuffix(base, ".go") || strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") {
+    return "", false
+  }
+  
+  dir := filepath.Dir(e.Name)
+  cnf, err := loadConfig(dir, cli)
+  if err != nil {
+    fmt.Printf("%v: %v\n", CMD, err)
+    return "", false
+  }
+  if strings.HasSuffix(base, *cnf.FileSuffix +".go") {
+    return "", false
+  }
+  
+  return dir, true
+}
+
+/**
+ * Regenerate a set of packages and report on how it went. Errors are
+ * reported but don't stop anything; the next change will try again.
+ */
+func regenerate(dirs []string, cli settings, opts options) {
+  var nerr int
+  start := time.Now()
+  
+  for _, e := range dirs {
+    cnf, err := loadConfig(e, cli)
+    if err == nil {
+      cnf.Apply()
+      err = procDir(e, opts)
+    }
+    if err != nil {
+      fmt.Printf("%v: %v: %v\n", CMD, e, err)
+      nerr++
+    }else if VERBOSE {
+      fmt.Printf("%v: %v: ok\n", CMD, e)
+    }
+  }
+  
+  err := flushOverlays()
+  if err != nil {
+    fmt.Printf("%v: %v\n", CMD, err)
+    nerr++
+  }
+  
+  fmt.Printf("%v: regenerated %d package(s) in %v; %d failed\n", CMD, len(dirs), time.Since(start).Round(time.Millisecond), nerr)
+}
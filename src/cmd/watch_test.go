@@ -0,0 +1,83 @@
+package main
+
+import (
+  "os"
+  "time"
+  "testing"
+  "path/filepath"
+  "github.com/fsnotify/fsnotify"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestWatchTarget(t *testing.T) {
+  dir := t.TempDir()
+  applySettings(t, settings{})
+  tests := []struct{
+    Name    string
+    Op      fsnotify.Op
+    Suffix  string
+    Target  bool
+  }{
+    {"a.go", fsnotify.Write, "", true},
+    {"a.go", fsnotify.Create, "", true},
+    {"a.go", fsnotify.Remove, "", true},
+    {"a.go", fsnotify.Rename, "", true},
+    {"a.go", fsnotify.Chmod, "", false},
+    {"a_ref.go", fsnotify.Write, "", false},          // our own output
+    {pkgSrc +"_ref.go", fsnotify.Create, "", false},
+    {".a_ref.go.1234", fsnotify.Create, "", false},   // a temporary from writeTemp
+    {".a.go", fsnotify.Write, "", false},
+    {"_a.go", fsnotify.Write, "", false},
+    {"a.go.swp", fsnotify.Write, "", false},
+    {"notes.txt", fsnotify.Write, "", false},
+    {"goref.yaml", fsnotify.Write, "", false},
+    {"a_ref.go", fsnotify.Write, "_gen", true},       // only output with the configured suffix is ignored
+    {"a_gen.go", fsnotify.Write, "_gen", false},
+  }
+  for _, e := range tests {
+    var cli settings
+    if e.Suffix != "" {
+      cli.FileSuffix = stringPtr(e.Suffix)
+    }
+    d, ok := watchTarget(fsnotify.Event{Name:filepath.Join(dir, e.Name), Op:e.Op}, cli)
+    assert.Equal(t, e.Target, ok, "%v %v", e.Name, e.Op)
+    if e.Target {
+      assert.Equal(t, dir, d, e.Name)
+    }
+  }
+}
+
+func TestWatchDebounce(t *testing.T) {
+  dir := t.TempDir()
+  applySettings(t, settings{})
+  
+  events := make(chan fsnotify.Event)
+  stop := make(chan os.Signal, 1)
+  done := make(chan error, 1)
+  runs := make(chan []string, 10)
+  go func() {
+    done <- watchEvents(events, make(chan error), stop, settings{}, func(dirs []string) {
+      runs <- dirs
+    })
+  }()
+  
+  // a burst of saves, each well within the debounce window of the last
+  for i := 0; i < 5; i++ {
+    events <- fsnotify.Event{Name:filepath.Join(dir, "a.go"), Op:fsnotify.Write}
+    events <- fsnotify.Event{Name:filepath.Join(dir, "a_ref.go"), Op:fsnotify.Write}
+    time.Sleep(watchDebounce / 10)
+  }
+  select {
+    case dirs := <-runs:
+      assert.Equal(t, []string{dir}, dirs)
+    case <-time.After(4 * watchDebounce):
+      assert.Fail(t, "Changes were not regenerated")
+  }
+  
+  // nothing more is pending
+  time.Sleep(2 * watchDebounce)
+  assert.Len(t, runs, 0)
+  
+  stop <- os.Interrupt
+  assert.Nil(t, <-done)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+  "fmt"
+  "go/ast"
+  "go/types"
+  "go/parser"
+  "reftag"
+)
+
+/**
+ * The kinds of values in the JSON that types are marshaled to
+ */
+type wireKind int
+const (
+  wireAny       = wireKind(iota)
+  wireBool      = wireKind(iota)
+  wireInteger   = wireKind(iota)
+  wireNumber    = wireKind(iota)
+  wireString    = wireKind(iota)
+  wireBytes     = wireKind(iota)
+  wireTime      = wireKind(iota)
+  wireArray     = wireKind(iota)
+  wireMap       = wireKind(iota)
+  wireObject    = wireKind(iota)
+  wireNamed     = wireKind(iota)
+)
+
+/**
+ * The JSON a Go type is marshaled to. Arrays and maps have an element type,
+ * maps a key type, objects have fields, and named types refer to a definition
+ * in the model by name. A nullable value may also be null. The Go type it's
+ * marshaled from is kept as written.
+ */
+type wireType struct {
+  Kind      wireKind
+  Elem      *wireType
+  Key       *wireType
+  Name      string
+  Fields    []*wireField
+  Nullable  bool
+  Go        string
+}
+
+/**
+ * A field in the JSON a struct is marshaled to. A ref field has a value and
+ * an id, under their own keys; either may be present but not both, and it is
+ * marshaled as the one its policy specifies. A promoted field belongs to an
+ * embedded struct.
+ */
+type wireField struct {
+  Name      string
+  Key       string
+  Type      *wireType
+  OmitEmpty bool
+  Promoted  bool
+  Ref       bool
+  RefType   string
+  IdKey     string
+  Id        *wireType
+  Marshal   reftag.Variant
+}
+
+/**
+ * The wire format of a package: the structs with ref fields, in the order
+ * they are declared, and the definitions of every named type they reach.
+ * Types declared in other packages are defined by their package's name and
+ * theirs, like orgs.Org; the packages are loaded once, by import path, and
+ * those that can't be found are noted as missing.
+ */
+type wireModel struct {
+  Package     string
+  Roots       []string
+  Names       []string
+  Types       map[string]*wireType
+  Loaded      map[string]*context
+  Qualifiers  map[*context]string
+  Missing     []string
+}
+
+/**
+ * Build the wire format of a processed package. Structs with ref fields are
+ * marshaled by the generated code, which ignores embedded fields; other
+ * structs are marshaled by encoding/json, which promotes the fields of an
+ * embedded struct if it is declared in the package.
+ */
+func buildWireModel(cxt *context) (*wireModel, error) {
+  m := &wireModel{
+    Package:    cxt.Package,
+    Types:      make(map[string]*wireType),
+    Loaded:     make(map[string]*context),
+    Qualifiers: make(map[*context]string),
+  }
+  
+  specs, err := marshalSpecs(cxt)
+  if err != nil {
+    return nil, err
+  }
+  for _, e := range specs {
+    m.Roots = append(m.Roots, e.Name.Name)
+    _, err := m.named(cxt, e.Name.Name)
+    if err != nil {
+      return nil, err
+    }
+  }
+  
+  return m, nil
+}
+
+/**
+ * Refer to a type declared in the package, or in another one that has been
+ * loaded, defining it in the model the first time. Generic types are not
+ * defined, since they have no single wire format.
+ */
+func (m *wireModel) named(cxt *context, n string) (*wireType, error) {
+  spec, ok := cxt.Types[n]
+  if !ok || spec.TypeParams != nil {
+    return &wireType{Kind:wireAny}, nil
+  }
+  k := n
+  if q, ok := m.Qualifiers[cxt]; ok {
+    k = q +"."+ n
+  }
+  if _, ok := m.Types[k]; !ok {
+    m.Types[k] = nil // a recursive reference finds it defined
+    m.Names = append(m.Names, k)
+    t, ok := marshalerOf(cxt, n)
+    if !ok {
+      var err error
+      t, err = m.typeOf(cxt, spec.Type)
+      if err != nil {
+        return nil, err
+      }
+    }
+    m.Types[k] = t
+  }
+  return &wireType{Kind:wireNamed, Name:k}, nil
+}
+
+/**
+ * Produce the wire format of a type that marshals itself, if it does. Text is
+ * marshaled as a string; JSON could be anything.
+ */
+func marshalerOf(cxt *context, n string) (*wireType, bool) {
+  if _, ok := cxt.Methods[n +".MarshalJSON"]; ok {
+    return &wireType{Kind:wireAny}, true
+  }
+  if _, ok := cxt.Methods[n +".MarshalText"]; ok {
+    return &wireType{Kind:wireString}, true
+  }
+  return nil, false
+}
+
+/**
+ * Refer to a type declared in another package, which is loaded to find it. A
+ * type that can't be found could be anything.
+ */
+func (m *wireModel) external(cxt *context, p, n string) (*wireType, error) {
+  ext, err := m.load(cxt, p)
+  if err != nil {
+    return nil, err
+  }
+  if ext == nil || ext.Types[n] == nil {
+    m.Missing = appendUnique(m.Missing, p +"."+ n)
+    return &wireType{Kind:wireAny}, nil
+  }
+  return m.named(ext, n)
+}
+
+/**
+ * Load another package for the model, qualifying its types. A package that
+ * can't be found is nil.
+ */
+func (m *wireModel) load(cxt *context, p string) (*context, error) {
+  if ext, ok := m.Loaded[p]; ok {
+    return ext, nil
+  }
+  m.Loaded[p] = nil // until it's loaded, a package referring back to it finds nothing
+  
+  ext, err := loadPackage(cxt, p)
+  if err != nil || ext == nil {
+    return nil, err
+  }
+  m.Loaded[p] = ext
+  m.Qualifiers[ext] = m.qualifier(ext.Package)
+  return ext, nil
+}
+
+/**
+ * Produce a name that qualifies the types of a loaded package, which is its
+ * package name unless another package already has it
+ */
+func (m *wireModel) qualifier(pkg string) string {
+  taken := map[string]bool{m.Package:true}
+  for _, e := range m.Qualifiers {
+    taken[e] = true
+  }
+  q := pkg
+  for i := 2; taken[q]; i++ {
+    q = fmt.Sprintf("%s%d", pkg, i)
+  }
+  return q
+}
+
+/**
+ * Produce the wire format of a type expression
+ */
+func (m *wireModel) typeOf(cxt *context, e ast.Expr) (*wireType, error) {
+  t, err := m.kindOf(cxt, e)
+  if err != nil {
+    return nil, err
+  }
+  t.Go = types.ExprString(e)
+  return t, nil
+}
+
+func (m *wireModel) kindOf(cxt *context, e ast.Expr) (*wireType, error) {
+  switch v := e.(type) {
+    
+    case *ast.Ident:
+      switch v.Name {
+        case "bool":
+          return &wireType{Kind:wireBool}, nil
+        case "string":
+          return &wireType{Kind:wireString}, nil
+        case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte", "rune":
+          return &wireType{Kind:wireInteger}, nil
+        case "float32", "float64":
+          return &wireType{Kind:wireNumber}, nil
+      }
+      return m.named(cxt, v.Name)
+      
+    case *ast.StarExpr:
+      t, err := m.typeOf(cxt, v.X)
+      if err != nil {
+        return nil, err
+      }
+      c := *t
+      c.Nullable = true
+      return &c, nil
+      
+    case *ast.ArrayType:
+      if x, ok := v.Elt.(*ast.Ident); ok && v.Len == nil && (x.Name == "byte" || x.Name == "uint8") {
+        return &wireType{Kind:wireBytes}, nil
+      }
+      t, err := m.typeOf(cxt, v.Elt)
+      if err != nil {
+        return nil, err
+      }
+      return &wireType{Kind:wireArray, Elem:t}, nil
+      
+    case *ast.MapType:
+      k, err := m.typeOf(cxt, v.Key)
+      if err != nil {
+        return nil, err
+      }
+      t, err := m.typeOf(cxt, v.Value)
+      if err != nil {
+        return nil, err
+      }
+      return &wireType{Kind:wireMap, Elem:t, Key:k}, nil
+      
+    case *ast.SelectorExpr:
+      p, ok := v.X.(*ast.Ident)
+      if !ok {
+        return &wireType{Kind:wireAny}, nil
+      }
+      s, ok := cxt.Imports[p.Name]
+      if !ok {
+        s, ok = cxt.Deps[p.Name] // the id type may be imported by configuration
+      }
+      if !ok {
+        return &wireType{Kind:wireAny}, nil
+      }
+      if importPath(s) == "time" && v.Sel.Name == "Time" {
+        return &wireType{Kind:wireTime}, nil
+      }
+      return m.external(cxt, importPath(s), v.Sel.Name)
+      
+    case *ast.StructType:
+      return m.object(cxt, v)
+      
+    default:
+      return &wireType{Kind:wireAny}, nil
+      
+  }
+}
+
+/**
+ * Produce the wire format of a struct
+ */
+func (m *wireModel) object(cxt *context, s *ast.StructType) (*wireType, error) {
+  generated := hasRefFields(s)
+  t := &wireType{Kind:wireObject}
+  if s.Fields == nil {
+    return t, nil
+  }
+  
+  for _, e := range s.Fields.List {
+    if len(e.Names) == 0 {
+      // an embedded struct's fields are promoted by encoding/json
+      x := e.Type
+      if v, ok := x.(*ast.StarExpr); ok {
+        x = v.X
+      }
+      v, ok := x.(*ast.Ident)
+      if generated || !ok || !ast.IsExported(v.Name) {
+        continue
+      }
+      spec, ok := cxt.Types[v.Name]
+      if !ok || spec.TypeParams != nil {
+        continue
+      }
+      if b, ok := spec.Type.(*ast.StructType); ok {
+        f, err := m.object(cxt, b)
+        if err != nil {
+          return nil, err
+        }
+        for _, x := range f.Fields {
+          x.Promoted = true
+          t.Fields = append(t.Fields, x)
+        }
+      }
+      continue
+    }
+    
+    for _, v := range e.Names {
+      if !ast.IsExported(v.Name) {
+        continue
+      }
+      policy, err := reftag.Parse(e, v.Name)
+      if err != nil {
+        return nil, err
+      }
+      if policy.Omit {
+        continue
+      }
+      
+      f := &wireField{Name:v.Name, Key:policy.Names.Value, OmitEmpty:policy.OmitEmpty}
+      if policy.Ref && generated {
+        f.Type, f.Id, f.RefType, err = m.refOf(cxt, e.Type)
+        f.Ref, f.IdKey, f.Marshal = true, policy.Names.Id, policy.Marshal
+      }else{
+        f.Type, err = m.typeOf(cxt, e.Type)
+      }
+      if err != nil {
+        return nil, err
+      }
+      t.Fields = append(t.Fields, f)
+    }
+  }
+  return t, nil
+}
+
+/**
+ * Produce the wire format of the value and id of a ref field, which has been
+ * rewritten to its ref type, and the name of the ref type
+ */
+func (m *wireModel) refOf(cxt *context, e ast.Expr) (*wireType, *wireType, string, error) {
+  ftype, err := parseIdent(e)
+  if err != nil {
+    return nil, nil, "", err
+  }
+  qual, name := refTypeName(ftype)
+  ref, ok := cxt.Lookup[qual + name]
+  if !ok {
+    return nil, nil, "", fmt.Errorf("No ref type found for: %s", qual + name)
+  }
+  
+  x, err := parser.ParseExpr(ref.Name)
+  if err != nil {
+    return nil, nil, "", err
+  }
+  val, err := m.typeOf(cxt, x)
+  if err != nil {
+    return nil, nil, "", err
+  }
+  x, err = parser.ParseExpr(idType)
+  if err != nil {
+    return nil, nil, "", err
+  }
+  id, err := m.typeOf(cxt, x)
+  if err != nil {
+    return nil, nil, "", err
+  }
+  
+  // a ref without a value or id omits it rather than writing null, so
+  // neither is nullable even when the field's type is a pointer
+  v, d := *val, *id
+  v.Nullable, d.Nullable = false, false
+  return &v, &d, qual + name, nil
+}
@@ -0,0 +1,341 @@
+package refcheck
+
+import (
+  "fmt"
+  "path"
+  "strings"
+  "strconv"
+  "reflect"
+  "go/ast"
+  "go/token"
+  "go/types"
+  "reftag"
+  "golang.org/x/tools/go/analysis"
+)
+
+/**
+ * The analyzer. It reports the same mistakes in `ref:"..."` tags that goref
+ * rejects when it generates code, so they show up in editors and go vet.
+ */
+var Analyzer = &analysis.Analyzer{
+  Name:             "refcheck",
+  Doc:              "check ref struct tags consumed by goref",
+  Run:              run,
+  RunDespiteErrors: true,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+  for _, f := range pass.Files {
+    scoped := scopedStructs(f)
+    ast.Inspect(f, func(n ast.Node) bool {
+      if s, ok := n.(*ast.StructType); ok {
+        checkStruct(pass, f, s, scoped[s])
+      }
+      return true
+    })
+  }
+  return nil, nil
+}
+
+/**
+ * Collect the structs goref can generate marshaling logic for: those declared
+ * at package scope and the anonymous structs their fields declare, directly or
+ * through a pointer.
+ */
+func scopedStructs(file *ast.File) map[*ast.StructType]bool {
+  scoped := make(map[*ast.StructType]bool)
+  
+  var add func(*ast.StructType)
+  add = func(s *ast.StructType) {
+    scoped[s] = true
+    if s.Fields == nil {
+      return
+    }
+    for _, e := range s.Fields.List {
+      x := e.Type
+      if v, ok := x.(*ast.StarExpr); ok {
+        x = v.X
+      }
+      if v, ok := x.(*ast.StructType); ok {
+        add(v)
+      }
+    }
+  }
+  
+  for _, d := range file.Decls {
+    if g, ok := d.(*ast.GenDecl); ok && g.Tok == token.TYPE {
+      for _, e := range g.Specs {
+        if s, ok := e.(*ast.TypeSpec).Type.(*ast.StructType); ok {
+          add(s)
+        }
+      }
+    }
+  }
+  return scoped
+}
+
+/**
+ * Check a struct. Like goref, selector types in a struct with ref fields
+ * must be backed by an import since the generated code depends on them.
+ */
+func checkStruct(pass *analysis.Pass, file *ast.File, s *ast.StructType, scoped bool) {
+  if s.Fields == nil {
+    return
+  }
+  
+  var refs int
+  for _, e := range s.Fields.List {
+    if checkField(pass, e) {
+      if !scoped {
+        pass.Reportf(e.Pos(), "Ref fields are only supported in struct types declared at package scope and the anonymous structs their fields declare")
+      }
+      refs++
+    }
+  }
+  if refs < 1 {
+    return
+  }
+  
+  for _, e := range s.Fields.List {
+    x := e.Type
+    for {
+      if v, ok := x.(*ast.StarExpr); ok {
+        x = v.X
+      }else{
+        break
+      }
+    }
+    if v, ok := x.(*ast.SelectorExpr); ok {
+      checkImport(pass, file, v)
+    }
+  }
+}
+
+/**
+ * Check a field, returning whether or not it is a ref field. Tags are parsed
+ * by the same rules goref uses.
+ */
+func checkField(pass *analysis.Pass, field *ast.Field) bool {
+  if field.Tag == nil || field.Tag.Kind != token.STRING {
+    return false
+  }
+  
+  tag, err := strconv.Unquote(field.Tag.Value)
+  if err != nil {
+    return false // the compiler will complain about this
+  }
+  rtag := reflect.StructTag(tag).Get(reftag.Tag)
+  if rtag == "" {
+    return false
+  }
+  
+  var name string
+  if len(field.Names) > 0 {
+    name = field.Names[0].Name
+  }
+  policy, err := reftag.Parse(field, name)
+  if e, ok := err.(*reftag.Error); ok && e.Flag != "" {
+    checkUnknownFlag(pass, field.Tag, tag, rtag, e)
+  }else if ok && e.Field {
+    pass.Reportf(field.Pos(), "%s", e.Message)
+  }else if err != nil {
+    pass.Reportf(field.Tag.Pos(), "%s", err.Error())
+  }else if policy.Omit {
+    return false // omitted entirely
+  }
+  
+  checkType(pass, field.Type)
+  if p := typeParam(pass.TypesInfo.TypeOf(field.Type)); p != nil {
+    pass.Reportf(field.Type.Pos(), "Referenced type cannot depend on a type parameter: %v", p)
+  }
+  return true
+}
+
+/**
+ * Report an unknown flag and suggest the flag that was most likely meant
+ */
+func checkUnknownFlag(pass *analysis.Pass, lit *ast.BasicLit, tag, rtag string, err *reftag.Error) {
+  flag := err.Flag
+  d := analysis.Diagnostic{
+    Pos:      lit.Pos(),
+    End:      lit.End(),
+    Message:  err.Message,
+  }
+  
+  if s := suggestFlag(flag); s != "" {
+    name, f := reftag.Split(rtag)
+    flags := strings.Split(f, ",")
+    for i, e := range flags {
+      if e == flag {
+        flags[i] = s
+      }
+    }
+    repl := strings.Replace(tag, reftag.Tag +`:"`+ rtag +`"`, reftag.Tag +`:"`+ strings.Join(append([]string{name}, flags...), ",") +`"`, 1)
+    d.SuggestedFixes = []analysis.SuggestedFix{{
+      Message:    fmt.Sprintf("Replace %q with %q", flag, s),
+      TextEdits:  []analysis.TextEdit{{Pos:lit.Pos(), End:lit.End(), NewText:[]byte(quoteLike(lit.Value, repl))}},
+    }}
+  }
+  
+  pass.Report(d)
+}
+
+/**
+ * Check that the referenced type is one goref can generate a ref for
+ */
+func checkType(pass *analysis.Pass, e ast.Expr) {
+  switch v := e.(type) {
+    case *ast.Ident:
+      if !ast.IsExported(v.Name) {
+        pass.Reportf(v.Pos(), "Referenced type must be exported: %v", v.Name)
+      }
+    case *ast.SelectorExpr:
+      if !ast.IsExported(v.Sel.Name) {
+        pass.Reportf(v.Pos(), "Referenced type must be exported: %v", v.Sel.Name)
+      }
+    case *ast.StarExpr:
+      checkType(pass, v.X)
+    case *ast.IndexExpr:
+      checkType(pass, v.X)
+    case *ast.IndexListExpr:
+      checkType(pass, v.X)
+    case *ast.ArrayType:
+      if v.Len != nil {
+        pass.Reportf(v.Pos(), "Array types are not supported; only slice types.")
+      }else{
+        checkType(pass, v.Elt)
+      }
+    case *ast.MapType:
+      checkType(pass, v.Value)
+    default:
+      pass.Reportf(e.Pos(), "Unsupported referenced type: %v", types.ExprString(e))
+  }
+}
+
+/**
+ * Find a type parameter a type depends on. Ref types are declared at package
+ * scope, where type parameters aren't defined.
+ */
+func typeParam(t types.Type) *types.TypeParam {
+  switch v := t.(type) {
+    case *types.TypeParam:
+      return v
+    case *types.Pointer:
+      return typeParam(v.Elem())
+    case *types.Slice:
+      return typeParam(v.Elem())
+    case *types.Array:
+      return typeParam(v.Elem())
+    case *types.Map:
+      if p := typeParam(v.Key()); p != nil {
+        return p
+      }
+      return typeParam(v.Elem())
+    case *types.Named:
+      args := v.TypeArgs()
+      for i := 0; i < args.Len(); i++ {
+        if p := typeParam(args.At(i)); p != nil {
+          return p
+        }
+      }
+  }
+  return nil
+}
+
+/**
+ * Check that the package a selector type refers to is imported by the file
+ * that declares the struct. If another file in the package imports it, we
+ * suggest the same import here.
+ */
+func checkImport(pass *analysis.Pass, file *ast.File, sel *ast.SelectorExpr) {
+  x := sel.X
+  for {
+    if v, ok := x.(*ast.SelectorExpr); ok {
+      x = v.X
+    }else{
+      break
+    }
+  }
+  id, ok := x.(*ast.Ident)
+  if !ok {
+    return
+  }
+  if _, ok := pass.TypesInfo.Uses[id].(*types.PkgName); ok {
+    return // resolved; it's imported
+  }
+  for _, e := range file.Imports {
+    if importName(e) == id.Name {
+      return
+    }
+  }
+  
+  d := analysis.Diagnostic{
+    Pos:      id.Pos(),
+    End:      id.End(),
+    Message:  fmt.Sprintf("Referenced package has no corresponding import: %v", id.Name),
+  }
+  
+  for _, f := range pass.Files {
+    for _, e := range f.Imports {
+      if importName(e) != id.Name {
+        continue
+      }
+      spec := e.Path.Value
+      if e.Name != nil {
+        spec = e.Name.Name +" "+ spec
+      }
+      pos, text := file.Name.End(), "\n\nimport "+ spec
+      for _, x := range file.Decls {
+        if g, ok := x.(*ast.GenDecl); ok && g.Tok == token.IMPORT {
+          pos, text = g.End(), "\nimport "+ spec
+        }
+      }
+      d.SuggestedFixes = []analysis.SuggestedFix{{
+        Message:    fmt.Sprintf("Add import %v", spec),
+        TextEdits:  []analysis.TextEdit{{Pos:pos, End:pos, NewText:[]byte(text)}},
+      }}
+      pass.Report(d)
+      return
+    }
+  }
+  
+  pass.Report(d)
+}
+
+/**
+ * Suggest a known flag for an unknown one; we're only concerned with
+ * obvious mistakes like case or abbreviation.
+ */
+func suggestFlag(f string) string {
+  l := strings.ToLower(strings.TrimSpace(f))
+  if l == "" {
+    return ""
+  }
+  for _, e := range reftag.Flags {
+    if l == e || strings.HasPrefix(e, l) || strings.HasPrefix(l, e) {
+      return e
+    }
+  }
+  return ""
+}
+
+/**
+ * Quote a string in the same style as the literal it replaces
+ */
+func quoteLike(orig, s string) string {
+  if strings.HasPrefix(orig, "`") && !strings.Contains(s, "`") {
+    return "`"+ s +"`"
+  }
+  return strconv.Quote(s)
+}
+
+func importName(e *ast.ImportSpec) string {
+  if e.Name != nil {
+    return e.Name.Name
+  }
+  p, err := strconv.Unquote(e.Path.Value)
+  if err != nil {
+    return ""
+  }
+  return path.Base(p)
+}
@@ -0,0 +1,10 @@
+package refcheck
+
+import (
+  "testing"
+  "golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+  analysistest.RunWithSuggestedFixes(t, analysistest.TestData(), Analyzer, "a")
+}
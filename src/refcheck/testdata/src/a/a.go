@@ -0,0 +1,89 @@
+package a
+
+import (
+  "encoding/json"
+)
+
+type thing struct{}
+
+type Thing struct{}
+
+type Valid struct {
+  A *Thing              `json:"a" ref:"a_id"`
+  B json.RawMessage     `json:"b" ref:"b_id,value"`
+  C []*Thing            `json:"c" ref:"c_ids,id"`
+  D map[string]Thing    `json:"d" ref:"d_ids"`
+  E *thing              `json:"-" ref:"e_id"`
+}
+
+type Flags struct {
+  A *Thing              `json:"a" ref:"a_id,val"` // want `Unknown ref tag flag: "val"`
+  B *Thing              `json:"b" ref:"b_id,ID"` // want `Unknown ref tag flag: "ID"`
+  C *Thing              `json:"c" ref:"c_id,lazy"` // want `Unknown ref tag flag: "lazy"`
+  D *Thing              `json:"d" ref:"d_id,id,value"` // want `more than one marshaling variant`
+}
+
+type Names struct {
+  A, B *Thing           `ref:"a_id"` // want `Field list has 2 identifiers for one tag`
+  C *Thing              `json:"c" ref:",value"` // want `Ref tag has no identifier key: ",value"`
+}
+
+type Types struct {
+  A *thing              `json:"a" ref:"a_id"` // want `Referenced type must be exported: thing`
+  B [2]Thing            `json:"b" ref:"b_id"` // want `Array types are not supported`
+  C func()              `json:"c" ref:"c_id"` // want `Unsupported referenced type: func\(\)`
+}
+
+type Pinned struct {
+  A *Thing              `json:"a" ref:"a_id,type=ThingLink"`
+  B *Thing              `json:"b" ref:"b_id,value,type=ThingLink"`
+  C *Thing              `json:"c" ref:"c_id,type=thingLink"` // want `Pinned ref type name must be an exported identifier: "thingLink"`
+  D *Thing              `json:"d" ref:"d_id,type="` // want `Pinned ref type name must be an exported identifier: ""`
+}
+
+type Page[T any] struct {
+  Items []T             `json:"items"`
+}
+
+type Pair[K comparable, V any] struct{}
+
+type Generic[T any] struct {
+  A *Page[Thing]        `json:"a" ref:"a_id"`
+  B Pair[string, Thing] `json:"b" ref:"b_id"`
+  C *Page[T]            `json:"c" ref:"c_id"` // want `Referenced type cannot depend on a type parameter: T`
+}
+
+type Inline struct {
+  A struct {
+    B *Thing            `json:"b" ref:"b_id"`
+    C *struct {
+      D *Thing          `json:"d" ref:"d_id"`
+    }                   `json:"c"`
+  }                     `json:"a"`
+  E []struct {
+    F *Thing            `json:"f" ref:"f_id"` // want `Ref fields are only supported in struct types declared at package scope`
+  }                     `json:"e"`
+}
+
+var Global struct {
+  A *Thing              `json:"a" ref:"a_id"` // want `Ref fields are only supported in struct types declared at package scope`
+}
+
+func Local() {
+  type T struct {
+    A *Thing            `json:"a" ref:"a_id"` // want `Ref fields are only supported in struct types declared at package scope`
+  }
+  _ = T{}
+}
+
+type Tables struct {
+  A *Thing              `json:"a" ref:"a_id,table=things"`
+  B *Thing              `json:"b" ref:"b_id,value,table=public.things"`
+  C *Thing              `json:"c" ref:"c_id,table=some-things"` // want `SQL table name must be an identifier, optionally qualified by a schema: "some-things"`
+}
+
+type URLs struct {
+  A *Thing              `json:"a" ref:"a_id,url=/things/{id}"`
+  B *Thing              `json:"b" ref:"b_id,url=/things?ids={ids}"`
+  C *Thing              `json:"c" ref:"c_id,url=/things"` // want `URL template must contain either \{id\} or \{ids\}: "/things"`
+}
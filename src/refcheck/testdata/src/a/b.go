@@ -0,0 +1,5 @@
+package a
+
+type Imports struct {
+  A *json.RawMessage    `json:"a" ref:"a_id"` // want `Referenced package has no corresponding import: json`
+}
@@ -0,0 +1,239 @@
+package reftag
+
+import (
+  "fmt"
+  "strings"
+  "strconv"
+  "reflect"
+  "go/ast"
+  "go/token"
+)
+
+/**
+ * Struct tag keys and the flag that omits empty values
+ */
+const (
+  Tag             = "ref"
+  JSONTag         = "json"
+  OmitEmpty       = "omitempty"
+)
+
+/**
+ * Flags that may follow the identifier key in a ref tag to select the
+ * variant a ref is marshaled as
+ */
+const (
+  IdFlag          = "id"
+  ValueFlag       = "value"
+)
+
+var Flags = []string{IdFlag, ValueFlag}
+
+/**
+ * Options that may follow the identifier key in a ref tag: the option that
+ * pins the name of the generated ref type, and those that map the referenced
+ * type to the SQL table or URL template its resolver loads it from
+ */
+const (
+  TypeOption      = "type"
+  TableOption     = "table"
+  URLOption       = "url"
+)
+
+/**
+ * Placeholders in a URL template: a single id, fetched one at a time, or a
+ * comma-separated list of ids, fetched as a batch
+ */
+const (
+  URLId           = "{id}"
+  URLIds          = "{ids}"
+)
+
+/**
+ * The variant a ref is marshaled as
+ */
+type Variant int
+const (
+  Id              = Variant(iota)
+  Value           = Variant(iota)
+)
+
+func (v Variant) String() string {
+  switch v {
+    case Id:
+      return IdFlag
+    case Value:
+      return ValueFlag
+    default:
+      return fmt.Sprintf("Variant(%d)", int(v))
+  }
+}
+
+/**
+ * The keys a field's id and value are marshaled under
+ */
+type Names struct {
+  Id, Value string
+}
+
+/**
+ * How a field is marshaled, as described by its tags
+ */
+type Policy struct {
+  Names   Names
+  Marshal Variant
+  Type    string
+  Table   string
+  URL     string
+  Ref, Omit, OmitEmpty bool
+}
+
+/**
+ * A problem with a field's tags. An unknown flag is provided so a checker
+ * can suggest the one that was meant; a problem with the field itself rather
+ * than its tags is noted as such.
+ */
+type Error struct {
+  Message string
+  Flag    string
+  Field   bool
+}
+
+func (e *Error) Error() string {
+  return e.Message
+}
+
+func errorf(f string, a ...interface{}) error {
+  return &Error{Message:fmt.Sprintf(f, a...)}
+}
+
+/**
+ * Determine how a field is marshaled from its tags. A field that isn't
+ * renamed by a json tag is marshaled under its name. Tags apply to every
+ * identifier in a field list, so a tagged field must only declare one.
+ */
+func Parse(field *ast.Field, name string) (Policy, error) {
+  var jtag, rtag, flags string
+  
+  if field.Tag != nil && field.Tag.Kind == token.STRING {
+    tag, err := strconv.Unquote(field.Tag.Value)
+    if err != nil {
+      return Policy{}, err
+    }
+    t := reflect.StructTag(tag)
+    jtag = t.Get(JSONTag)
+    rtag = t.Get(Tag)
+  }
+  
+  if jtag == "-" || rtag == "-" {
+    return Policy{Omit:true}, nil
+  }else if (jtag != "" || rtag != "") && len(field.Names) > 1 {
+    return Policy{}, &Error{Message:fmt.Sprintf("Field list has %d identifiers for one tag", len(field.Names)), Field:true}
+  }
+  
+  policy := Policy{}
+  
+  if jtag != "" {
+    var key string
+    key, flags = Split(jtag)
+    if key != "" {
+      name = key
+    }
+    for _, e := range strings.Split(flags, ",") {
+      policy.OmitEmpty = policy.OmitEmpty || e == OmitEmpty
+    }
+  }
+  
+  policy.Names.Value = name
+  
+  if rtag != "" {
+    name, flags = Split(rtag)
+    policy.Ref = true
+    if name == "" {
+      return Policy{}, errorf("Ref tag has no identifier key: %q", rtag)
+    }
+  }
+  
+  policy.Names.Id = name
+  policy.Marshal = Id
+  if !policy.Ref || flags == "" {
+    return policy, nil
+  }
+  
+  var variants []string
+  for _, e := range strings.Split(flags, ",") {
+    switch {
+      case e == IdFlag:
+        policy.Marshal = Id
+        variants = append(variants, e)
+      case e == ValueFlag:
+        policy.Marshal = Value
+        variants = append(variants, e)
+      case strings.HasPrefix(e, TypeOption +"="):
+        policy.Type = e[len(TypeOption) + 1:]
+        if !token.IsIdentifier(policy.Type) || !ast.IsExported(policy.Type) {
+          return Policy{}, errorf("Pinned ref type name must be an exported identifier: %q", policy.Type)
+        }
+      case strings.HasPrefix(e, TableOption +"="):
+        policy.Table = e[len(TableOption) + 1:]
+        if !IsTableName(policy.Table) {
+          return Policy{}, errorf("SQL table name must be an identifier, optionally qualified by a schema: %q", policy.Table)
+        }
+      case strings.HasPrefix(e, URLOption +"="):
+        policy.URL = e[len(URLOption) + 1:]
+        err := CheckURL(policy.URL)
+        if err != nil {
+          return Policy{}, err
+        }
+      default:
+        return Policy{}, &Error{
+          Message: fmt.Sprintf("Unknown ref tag flag: %q (expected one of: %s, %s=<name>, %s=<table>, %s=<url>)", e, strings.Join(Flags, ", "), TypeOption, TableOption, URLOption),
+          Flag:    e,
+        }
+    }
+  }
+  if len(variants) > 1 {
+    return Policy{}, errorf("Ref tag specifies more than one marshaling variant: %s", strings.Join(variants, ", "))
+  }
+  
+  return policy, nil
+}
+
+/**
+ * Split a tag into its key and flags
+ */
+func Split(t string) (string, string) {
+  if x := strings.Index(t, ","); x >= 0 {
+    return t[:x], t[x+1:]
+  }else{
+    return t, ""
+  }
+}
+
+/**
+ * Determine whether a table name is usable as is: an identifier, optionally
+ * qualified by a schema
+ */
+func IsTableName(s string) bool {
+  parts := strings.Split(s, ".")
+  if len(parts) > 2 {
+    return false
+  }
+  for _, e := range parts {
+    if !token.IsIdentifier(e) {
+      return false
+    }
+  }
+  return true
+}
+
+/**
+ * Check that a URL template has exactly one kind of placeholder
+ */
+func CheckURL(t string) error {
+  one, batch := strings.Contains(t, URLId), strings.Contains(t, URLIds)
+  if one == batch {
+    return errorf("URL template must contain either %s or %s: %q", URLId, URLIds, t)
+  }
+  return nil
+}
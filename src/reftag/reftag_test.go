@@ -0,0 +1,67 @@
+package reftag
+
+import (
+  "fmt"
+  "testing"
+  "go/ast"
+  "go/parser"
+  "github.com/stretchr/testify/assert"
+)
+
+func parseField(t *testing.T, src string) *ast.Field {
+  x, err := parser.ParseExpr("struct{ "+ src +" }")
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    t.FailNow()
+  }
+  return x.(*ast.StructType).Fields.List[0]
+}
+
+func TestParse(t *testing.T) {
+  tests := []struct{
+    Field   string
+    Expect  Policy
+    Error   string
+  }{
+    {"A *T", Policy{Names:Names{"A", "A"}}, ""},
+    {"A *T `json:\"a\"`", Policy{Names:Names{"a", "a"}}, ""},
+    {"A *T `json:\",omitempty\"`", Policy{Names:Names{"A", "A"}, OmitEmpty:true}, ""},
+    {"A *T `json:\"a,string,omitempty\"`", Policy{Names:Names{"a", "a"}, OmitEmpty:true}, ""},
+    {"A *T `json:\"a\" ref:\"a_id\"`", Policy{Names:Names{"a_id", "a"}, Ref:true}, ""},
+    {"A *T `ref:\"a_id,value,type=TLink,table=s.t\"`", Policy{Names:Names{"a_id", "A"}, Ref:true, Marshal:Value, Type:"TLink", Table:"s.t"}, ""},
+    {"A *T `ref:\"a_id,url=/t/{id}\"`", Policy{Names:Names{"a_id", "A"}, Ref:true, URL:"/t/{id}"}, ""},
+    {"A *T `json:\"-\" ref:\"a_id\"`", Policy{Omit:true}, ""},
+    {"A, B *T", Policy{Names:Names{"A", "A"}}, ""},
+    {"A, B *T `json:\"-\"`", Policy{Omit:true}, ""},
+    {"A, B *T `json:\"a\"`", Policy{}, "Field list has 2 identifiers for one tag"},
+    {"A, B *T `ref:\"a_id\"`", Policy{}, "Field list has 2 identifiers for one tag"},
+    {"A *T `ref:\",value\"`", Policy{}, `Ref tag has no identifier key: ",value"`},
+    {"A *T `ref:\"a_id,id,value\"`", Policy{}, "Ref tag specifies more than one marshaling variant: id, value"},
+    {"A *T `ref:\"a_id,type=tLink\"`", Policy{}, `Pinned ref type name must be an exported identifier: "tLink"`},
+    {"A *T `ref:\"a_id,table=some-t\"`", Policy{}, `SQL table name must be an identifier, optionally qualified by a schema: "some-t"`},
+    {"A *T `ref:\"a_id,url=/t\"`", Policy{}, `URL template must contain either {id} or {ids}: "/t"`},
+  }
+  for _, e := range tests {
+    f := parseField(t, e.Field)
+    p, err := Parse(f, f.Names[0].Name)
+    if e.Error != "" {
+      assert.EqualError(t, err, e.Error, e.Field)
+    }else if assert.Nil(t, err, e.Field) {
+      assert.Equal(t, e.Expect, p, e.Field)
+    }
+  }
+}
+
+func TestParseUnknownFlag(t *testing.T) {
+  f := parseField(t, "A *T `ref:\"a_id,val\"`")
+  _, err := Parse(f, "A")
+  if e, ok := err.(*Error); assert.True(t, ok, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, "val", e.Flag)
+    assert.False(t, e.Field)
+  }
+  
+  f = parseField(t, "A, B *T `ref:\"a_id\"`")
+  _, err = Parse(f, "A")
+  if e, ok := err.(*Error); assert.True(t, ok, fmt.Sprintf("%v", err)) {
+    assert.True(t, e.Field)
+  }
+}
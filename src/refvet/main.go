@@ -0,0 +1,13 @@
+package main
+
+import (
+  "refcheck"
+  "golang.org/x/tools/go/analysis/singlechecker"
+)
+
+/**
+ * Check ref tags; run directly on packages or via go vet -vettool
+ */
+func main() {
+  singlechecker.Main(refcheck.Analyzer)
+}
@@ -0,0 +1,81 @@
+#!/usr/bin/env bash
+#
+# Generate test fixtures and run the tests they contain.
+#
+# Each fixture is a directory of sources, excluded from builds, whose tests
+# exercise the code generated for them. Fixtures are copied into a scratch
+# module and generated there with the settings in their own goref.yaml, if
+# they have one. The rewritten copies of the sources carry the tests, so they
+# are renamed as test files before the module is tested.
+#
+# A fixture with a pb directory is also converted to protobuf. The proto file
+# goref generates for it must match the one checked in there, which the
+# messages beside it were generated from by protoc-gen-go; when it changes,
+# they must be generated again.
+#
+# usage: run.sh <fixture> [<fixture> ...]
+#
+
+set -euo pipefail
+
+root="$(cd "$(dirname "$0")/../.." && pwd)"
+goref="${GOREF:-$root/bin/goref}"
+suffix="_ref"
+
+if [ $# -lt 1 ]; then
+  echo "usage: $(basename "$0") <fixture> [<fixture> ...]" >&2
+  exit 2
+fi
+if [ ! -x "$goref" ]; then
+  echo "$(basename "$0"): $goref is not built; run make build" >&2
+  exit 1
+fi
+
+work="$(mktemp -d)"
+trap 'rm -rf "$work"' EXIT
+
+printf 'module fixtures\n\ngo 1.22\n' > "$work/go.mod"
+
+dirs=()
+for src in "$@"; do
+  name="$(basename "$src")"
+  cp -R "$src" "$work/$name"
+  dirs+=("$work/$name")
+done
+
+# goref reports problems but doesn't fail, so check that every source was
+# generated
+(cd "$work" && "$goref" -force "${dirs[@]}")
+for dir in "${dirs[@]}"; do
+  if [ -d "$dir/pb" ]; then
+    name="$(basename "$dir")"
+    out="$work/.proto-$name"
+    mkdir "$out"
+    (cd "$work" && "$goref" proto -go-package "fixtures/$name/pb" -out "$out" "$dir")
+    if [ ! -f "$dir/pkg_proto$suffix.go" ]; then
+      echo "$(basename "$0"): $dir was not converted to protobuf" >&2
+      exit 1
+    fi
+    for f in "$dir"/pb/*.proto; do
+      if ! diff -u "$f" "$out/$(basename "$f")"; then
+        echo "$(basename "$0"): $f is out of date; generate it and its messages again" >&2
+        exit 1
+      fi
+    done
+  fi
+  for f in "$dir"/*.go; do
+    case "$f" in
+      *"$suffix".go) continue ;;
+    esac
+    gen="${f%.go}$suffix.go"
+    if [ ! -f "$gen" ]; then
+      echo "$(basename "$0"): $f was not generated" >&2
+      exit 1
+    fi
+    mv "$gen" "${f%.go}${suffix}_test.go"
+  done
+done
+
+cd "$work"
+go mod tidy
+go test -count=1 ${TEST_FLAGS:-} ./...
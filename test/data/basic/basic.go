@@ -47,11 +47,24 @@ type Q struct {
   B *X                  `json:"b"`
 }
 
+// R refers to an X, which it marshals by value
 type R struct {
   A int                 `json:"a"`
+  // B is the referenced X
   B *X                  `json:"b" ref:"b_id,value"`
 }
 
+type S struct {
+  A int                 `json:"a"`
+  B struct {
+    C *X                `json:"c" ref:"c_id,value"`
+    D string            `json:"d"`
+  }                     `json:"b"`
+  E *struct {
+    F *X                `json:"f" ref:"f_id,value"`
+  }                     `json:"e,omitempty"`
+}
+
 func TestMarshalRoundtrip(t *testing.T) {
   var s []byte
   var err error
@@ -156,4 +169,60 @@ func TestMarshalRoundtrip(t *testing.T) {
     assert.Equal(t, r.B, r1.B)
   }
   
+  v := &S{A:123}
+  v.B.C = NewXRef(x)
+  v.B.D = "d"
+  
+  s, err = json.Marshal(v)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, `{"a":123,"b":{"c":{"a":123,"b":{"a":123}},"d":"d"}}`, string(s))
+  }
+  
+  var v1 S
+  err = json.Unmarshal(s, &v1)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, v.A, v1.A)
+    assert.Equal(t, v.B, v1.B)
+    assert.Nil(t, v1.E)
+  }
+  
+  e := `{"a":123,"b":{"d":""},"e":{"f":{"a":123,"b":{"a":123}}}}`
+  var v2 S
+  err = json.Unmarshal([]byte(e), &v2)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    if assert.NotNil(t, v2.E) {
+      assert.Equal(t, x, v2.E.F.Value)
+    }
+  }
+  
+  s, err = json.Marshal(v2)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, e, string(s))
+  }
+  
+}
+
+func TestColumnRoundtrip(t *testing.T) {
+  var r R
+  c := NewXRefColumn(&r.B)
+  
+  v, err := c.Value()
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Nil(t, v) // a nil ref is NULL
+  }
+  
+  err = c.Scan([]byte("123"))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) && assert.NotNil(t, r.B) {
+    assert.Equal(t, "123", r.B.Id)
+  }
+  
+  v, err = c.Value()
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, "123", v)
+  }
+  
+  err = c.Scan(nil)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Nil(t, r.B)
+  }
 }
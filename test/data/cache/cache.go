@@ -0,0 +1,94 @@
+// +build ignore
+
+package main
+
+import (
+  "sync"
+  "time"
+  "context"
+  "testing"
+  "sync/atomic"
+  "encoding/json"
+  "github.com/stretchr/testify/assert"
+)
+
+type Owner struct {
+  ID   string             `json:"id"`
+  Name string             `json:"name"`
+}
+
+type Repo struct {
+  Name  string            `json:"name"`
+  Owner *Owner            `json:"owner" ref:"owner_id"`
+}
+
+func TestCachedLoad(t *testing.T) {
+  var n int
+  cache := NewOwnerRefCache(func(cxt context.Context, id string) (*Owner, error) {
+    n++
+    if id == "0" {
+      return nil, nil
+    }
+    return &Owner{ID:id, Name:"Owner "+ id}, nil
+  }, 10, time.Minute)
+  cache.NegativeTTL = time.Minute
+  
+  for i := 0; i < 3; i++ {
+    var r Repo
+    err := json.Unmarshal([]byte(`{"name":"goref","owner_id":"123"}`), &r)
+    if !assert.Nil(t, err) {
+      return
+    }
+    u, err := r.Owner.WithLoader(cache.Loader(), false).Get(context.Background())
+    if assert.Nil(t, err) {
+      assert.Equal(t, "Owner 123", u.Name)
+    }
+    u, err = cache.Load(context.Background(), "0")
+    assert.Nil(t, err)
+    assert.Nil(t, u)
+  }
+  assert.Equal(t, 2, n)
+  assert.Equal(t, OwnerRefCacheStats{Hits:4, Misses:2, Size:2}, cache.Stats())
+  
+  cache.Invalidate("123")
+  _, err := cache.Load(context.Background(), "123")
+  assert.Nil(t, err)
+  assert.Equal(t, 3, n)
+}
+
+func TestCachedLoadShared(t *testing.T) {
+  var n int32
+  release := make(chan struct{})
+  cache := NewOwnerRefCache(func(cxt context.Context, id string) (*Owner, error) {
+    atomic.AddInt32(&n, 1)
+    <-release
+    return &Owner{ID:id, Name:"Owner "+ id}, nil
+  }, 10, time.Minute)
+  
+  const loads = 10
+  var wg sync.WaitGroup
+  for i := 0; i < loads; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      u, err := cache.Load(context.Background(), "123")
+      if assert.Nil(t, err) {
+        assert.Equal(t, "Owner 123", u.Name)
+      }
+    }()
+  }
+  
+  // release the load only once every other one is waiting for it
+  for {
+    s := cache.Stats()
+    if s.Misses + s.Shared == loads {
+      break
+    }
+    time.Sleep(time.Millisecond)
+  }
+  close(release)
+  wg.Wait()
+  
+  assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+  assert.Equal(t, OwnerRefCacheStats{Misses:1, Shared:loads - 1, Size:1}, cache.Stats())
+}
@@ -0,0 +1,215 @@
+// +build ignore
+
+package main
+
+import (
+  "fmt"
+  "sync"
+  "errors"
+  "time"
+  "strings"
+  "context"
+  "testing"
+  "net/http"
+  "sync/atomic"
+  "encoding/json"
+  "net/http/httptest"
+  "github.com/stretchr/testify/assert"
+)
+
+type User struct {
+  ID   string             `json:"id"`
+  Name string             `json:"name"`
+}
+
+type Team struct {
+  ID   string             `json:"id"`
+  Name string             `json:"name"`
+}
+
+type Org struct {
+  Slug string             `json:"slug"`
+  Name string             `json:"name"`
+}
+
+type Post struct {
+  Author *User            `json:"author" ref:"author_id,url=/users/{id}"`
+  Team   *Team            `json:"team" ref:"team_id,url=/teams?ids={ids}"`
+  Org    *Org             `json:"org" ref:"org_id,url=/orgs?slugs={ids}"`
+}
+
+/**
+ * A server that records the requests made of it and answers them with a
+ * handler
+ */
+type fakeServer struct {
+  sync.Mutex
+  *httptest.Server
+  Requests []string
+}
+
+func newFakeServer(t *testing.T, fn func(*http.Request) (int, interface{})) *fakeServer {
+  s := &fakeServer{}
+  s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    s.Lock()
+    s.Requests = append(s.Requests, r.URL.String())
+    s.Unlock()
+    status, v := fn(r)
+    w.WriteHeader(status)
+    if v != nil {
+      json.NewEncoder(w).Encode(v)
+    }
+  }))
+  t.Cleanup(s.Close)
+  return s
+}
+
+/**
+ * Obtain the requests made so far and forget them
+ */
+func (s *fakeServer) Seen() []string {
+  s.Lock()
+  defer s.Unlock()
+  r := s.Requests
+  s.Requests = nil
+  return r
+}
+
+func TestResolveId(t *testing.T) {
+  s := newFakeServer(t, func(r *http.Request) (int, interface{}) {
+    switch r.URL.Path {
+      case "/users/1":
+        return http.StatusOK, &User{ID:"1", Name:"Alice"}
+      case "/users/a b":
+        return http.StatusOK, &User{ID:"a b", Name:"Bob"}
+    }
+    return http.StatusNotFound, nil
+  })
+  
+  a, b, c, d := NewUserRefId("1"), NewUserRefId("1"), NewUserRefId("a b"), NewUserRefId("9")
+  err := NewUserRefHTTPResolver(s.URL).Resolve(context.Background(), a, b, c, d)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  assert.Equal(t, []string{"/users/1", "/users/a%20b", "/users/9"}, s.Seen())
+  assert.Equal(t, &User{ID:"1", Name:"Alice"}, a.Value)
+  assert.True(t, a.Value == b.Value, "Refs to the same id don't share a value")
+  assert.Equal(t, &User{ID:"a b", Name:"Bob"}, c.Value)
+  assert.Nil(t, d.Value) // a 404 leaves the ref without a value
+}
+
+func TestResolveIds(t *testing.T) {
+  teams := map[string]*Team{"a":{ID:"a", Name:"A"}, "b":{ID:"b", Name:"B"}, "c":{ID:"c", Name:"C"}}
+  s := newFakeServer(t, func(r *http.Request) (int, interface{}) {
+    var v []*Team
+    for _, e := range strings.Split(r.URL.Query().Get("ids"), ",") {
+      if x, ok := teams[e]; ok {
+        v = append(v, x)
+      }
+    }
+    return http.StatusOK, v
+  })
+  
+  r := NewTeamRefHTTPResolver(s.URL)
+  r.BatchSize = 2
+  a, b, c, d := NewTeamRefId("a"), NewTeamRefId("b"), NewTeamRefId("c"), NewTeamRefId("x")
+  err := r.Resolve(context.Background(), a, b, d, c)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  assert.Equal(t, []string{"/teams?ids=a,b", "/teams?ids=x,c"}, s.Seen())
+  assert.Equal(t, "A", a.Value.Name)
+  assert.Equal(t, "B", b.Value.Name)
+  assert.Equal(t, "C", c.Value.Name)
+  assert.Nil(t, d.Value) // not in the response
+}
+
+func TestResolveIdOf(t *testing.T) {
+  s := newFakeServer(t, func(r *http.Request) (int, interface{}) {
+    return http.StatusOK, []*Org{{Slug:"acme", Name:"Acme"}}
+  })
+  
+  // without an Id field, values can only be matched by IdOf
+  r := NewOrgRefHTTPResolver(s.URL)
+  a := NewOrgRefId("acme")
+  err := r.Resolve(context.Background(), a)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Nil(t, a.Value)
+  }
+  
+  r.IdOf = func(v *Org) string {
+    return v.Slug
+  }
+  err = r.Resolve(context.Background(), a)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) && assert.NotNil(t, a.Value) {
+    assert.Equal(t, "Acme", a.Value.Name)
+  }
+}
+
+func TestResolveRetries(t *testing.T) {
+  var fail int32
+  s := newFakeServer(t, func(r *http.Request) (int, interface{}) {
+    if atomic.AddInt32(&fail, -1) >= 0 {
+      return http.StatusServiceUnavailable, nil
+    }
+    return http.StatusOK, &User{ID:"1", Name:"Alice"}
+  })
+  r := NewUserRefHTTPResolver(s.URL)
+  r.Backoff = time.Millisecond
+  
+  // failures that may pass are retried
+  atomic.StoreInt32(&fail, 2)
+  a := NewUserRefId("1")
+  err := r.Resolve(context.Background(), a)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Len(t, s.Seen(), 3)
+    assert.NotNil(t, a.Value)
+  }
+  
+  // until there are no retries left
+  atomic.StoreInt32(&fail, 3)
+  err = r.Resolve(context.Background(), NewUserRefId("1"))
+  assert.EqualError(t, err, "GET "+ s.URL +"/users/1: 503 Service Unavailable")
+  assert.Len(t, s.Seen(), 3)
+}
+
+func TestResolveBackoff(t *testing.T) {
+  s := newFakeServer(t, func(r *http.Request) (int, interface{}) {
+    return http.StatusServiceUnavailable, nil
+  })
+  
+  // no backoff is the default, not none at all
+  r := &UserRefHTTPResolver{BaseURL:s.URL, Retries:1}
+  start := time.Now()
+  err := r.Resolve(context.Background(), NewUserRefId("1"))
+  assert.NotNil(t, err)
+  assert.True(t, time.Since(start) >= 100 * time.Millisecond, "Retried without waiting")
+}
+
+func TestResolveCancel(t *testing.T) {
+  first := make(chan struct{})
+  var once sync.Once
+  s := newFakeServer(t, func(r *http.Request) (int, interface{}) {
+    once.Do(func() {
+      close(first)
+    })
+    return http.StatusServiceUnavailable, nil
+  })
+  r := NewUserRefHTTPResolver(s.URL)
+  r.Backoff = time.Hour
+  
+  cxt, cancel := context.WithCancel(context.Background())
+  done := make(chan error)
+  go func() {
+    done <- r.Resolve(cxt, NewUserRefId("1"))
+  }()
+  <-first
+  cancel()
+  select {
+    case err := <-done:
+      assert.True(t, errors.Is(err, context.Canceled), fmt.Sprintf("%v", err))
+    case <-time.After(5 * time.Second):
+      t.Fatal("Resolve did not return when its context was canceled")
+  }
+  assert.Len(t, s.Seen(), 1)
+}
@@ -0,0 +1,121 @@
+// +build ignore
+
+package main
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+  "encoding/hex"
+  "encoding/json"
+  "database/sql/driver"
+  "github.com/stretchr/testify/assert"
+)
+
+/**
+ * An identifier that scans and stores itself, like most UUID packages
+ */
+type UUID [16]byte
+
+func (u UUID) String() string {
+  return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+func (u UUID) MarshalText() ([]byte, error) {
+  return []byte(u.String()), nil
+}
+
+func (u *UUID) UnmarshalText(text []byte) error {
+  b, err := hex.DecodeString(strings.Replace(string(text), "-", "", -1))
+  if err != nil {
+    return err
+  }
+  if len(b) != len(u) {
+    return fmt.Errorf("Invalid UUID: %q", text)
+  }
+  copy(u[:], b)
+  return nil
+}
+
+func (u *UUID) Scan(src interface{}) error {
+  switch s := src.(type) {
+    case []byte:
+      if len(s) == len(u) {
+        copy(u[:], s) // raw bytes, as some drivers return them
+        return nil
+      }
+      return u.UnmarshalText(s)
+    case string:
+      return u.UnmarshalText([]byte(s))
+  }
+  return fmt.Errorf("Cannot scan %T into a UUID", src)
+}
+
+func (u UUID) Value() (driver.Value, error) {
+  return u.String(), nil
+}
+
+type Account struct {
+  ID   UUID               `json:"id"`
+  Name string             `json:"name"`
+}
+
+type Session struct {
+  Account *Account        `json:"account" ref:"account_id"`
+}
+
+const accountId = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+func TestIdentScanner(t *testing.T) {
+  var id UUID
+  if !assert.Nil(t, id.UnmarshalText([]byte(accountId))) {
+    return
+  }
+  
+  for _, e := range []interface{}{accountId, []byte(accountId), id[:]} {
+    var r AccountRef
+    err := r.Scan(e)
+    if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+      assert.Equal(t, id, r.Id)
+    }
+  }
+  var r AccountRef
+  assert.NotNil(t, r.Scan(int64(1)))
+}
+
+func TestIdentValuer(t *testing.T) {
+  var id UUID
+  if !assert.Nil(t, id.UnmarshalText([]byte(accountId))) {
+    return
+  }
+  
+  r := NewAccountRefId(id)
+  v, err := NewAccountRefColumn(&r).Value()
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, accountId, v)
+  }
+  
+  r = NewAccountRef(&Account{ID:id})
+  v, err = NewAccountRefColumn(&r).Value()
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, accountId, v)
+  }
+  
+  r = &AccountRef{} // the zero id is NULL
+  v, err = NewAccountRefColumn(&r).Value()
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Nil(t, v)
+  }
+}
+
+func TestIdentJSON(t *testing.T) {
+  var s Session
+  err := json.Unmarshal([]byte(`{"account_id":"`+ accountId +`"}`), &s)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) && assert.NotNil(t, s.Account) {
+    assert.Equal(t, accountId, s.Account.Id.String())
+  }
+  data, err := json.Marshal(s)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.Equal(t, `{"account_id":"`+ accountId +`"}`, string(data))
+  }
+}
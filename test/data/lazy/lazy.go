@@ -0,0 +1,83 @@
+// +build ignore
+
+package main
+
+import (
+  "sync"
+  "time"
+  "context"
+  "testing"
+  "sync/atomic"
+  "encoding/json"
+  "github.com/stretchr/testify/assert"
+)
+
+type User struct {
+  ID   int64              `json:"id"`
+  Name string             `json:"name" db:"user_name"`
+}
+
+type Team struct {
+  ID   int64              `json:"id"`
+  Name string             `json:"name"`
+}
+
+type Post struct {
+  Title  string           `json:"title"`
+  Author *User            `json:"author" ref:"author_id,table=users"`
+  Team   *Team            `json:"team" ref:"team_id,url=/teams?ids={ids}"`
+}
+
+func TestLazyLoad(t *testing.T) {
+  var p Post
+  err := json.Unmarshal([]byte(`{"title":"Hello","author_id":"123"}`), &p)
+  if !assert.Nil(t, err) {
+    return
+  }
+  
+  var n int
+  p.Author.WithLoader(func(cxt context.Context, id string) (*User, error) {
+    n++
+    return &User{Name:"User "+ id}, nil
+  }, false)
+  
+  for i := 0; i < 2; i++ {
+    u, err := p.Author.Get(context.Background())
+    if assert.Nil(t, err) {
+      assert.Equal(t, "User 123", u.Name)
+    }
+  }
+  assert.Equal(t, 1, n)
+}
+
+func TestLazyLoadConcurrent(t *testing.T) {
+  var p Post
+  err := json.Unmarshal([]byte(`{"title":"Hello","author_id":"123"}`), &p)
+  if !assert.Nil(t, err) {
+    return
+  }
+  
+  var n int32
+  release := make(chan struct{})
+  p.Author.WithLoader(func(cxt context.Context, id string) (*User, error) {
+    atomic.AddInt32(&n, 1)
+    <-release
+    return &User{Name:"User "+ id}, nil
+  }, false)
+  
+  var wg sync.WaitGroup
+  for i := 0; i < 10; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      u, err := p.Author.Get(context.Background())
+      if assert.Nil(t, err) {
+        assert.Equal(t, "User 123", u.Name)
+      }
+    }()
+  }
+  time.Sleep(10 * time.Millisecond) // let them all contend for the load
+  close(release)
+  wg.Wait()
+  assert.Equal(t, int32(1), atomic.LoadInt32(&n))
+}
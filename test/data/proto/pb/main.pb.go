@@ -0,0 +1,528 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: main.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Post struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Title string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	// Types that are valid to be assigned to AuthorRef:
+	//
+	//	*Post_AuthorId
+	//	*Post_Author
+	AuthorRef isPost_AuthorRef `protobuf_oneof:"author_ref"`
+	// Types that are valid to be assigned to EditorsRef:
+	//
+	//	*Post_EditorIds
+	//	*Post_Editors
+	EditorsRef isPost_EditorsRef `protobuf_oneof:"editors_ref"`
+	// Types that are valid to be assigned to ReadersRef:
+	//
+	//	*Post_ReaderIds
+	//	*Post_Readers
+	ReadersRef    isPost_ReadersRef `protobuf_oneof:"readers_ref"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Post) Reset() {
+	*x = Post{}
+	mi := &file_main_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Post) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Post) ProtoMessage() {}
+
+func (x *Post) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Post.ProtoReflect.Descriptor instead.
+func (*Post) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Post) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Post) GetAuthorRef() isPost_AuthorRef {
+	if x != nil {
+		return x.AuthorRef
+	}
+	return nil
+}
+
+func (x *Post) GetAuthorId() string {
+	if x != nil {
+		if x, ok := x.AuthorRef.(*Post_AuthorId); ok {
+			return x.AuthorId
+		}
+	}
+	return ""
+}
+
+func (x *Post) GetAuthor() *User {
+	if x != nil {
+		if x, ok := x.AuthorRef.(*Post_Author); ok {
+			return x.Author
+		}
+	}
+	return nil
+}
+
+func (x *Post) GetEditorsRef() isPost_EditorsRef {
+	if x != nil {
+		return x.EditorsRef
+	}
+	return nil
+}
+
+func (x *Post) GetEditorIds() string {
+	if x != nil {
+		if x, ok := x.EditorsRef.(*Post_EditorIds); ok {
+			return x.EditorIds
+		}
+	}
+	return ""
+}
+
+func (x *Post) GetEditors() *Post_EditorsValues {
+	if x != nil {
+		if x, ok := x.EditorsRef.(*Post_Editors); ok {
+			return x.Editors
+		}
+	}
+	return nil
+}
+
+func (x *Post) GetReadersRef() isPost_ReadersRef {
+	if x != nil {
+		return x.ReadersRef
+	}
+	return nil
+}
+
+func (x *Post) GetReaderIds() string {
+	if x != nil {
+		if x, ok := x.ReadersRef.(*Post_ReaderIds); ok {
+			return x.ReaderIds
+		}
+	}
+	return ""
+}
+
+func (x *Post) GetReaders() *Post_ReadersValues {
+	if x != nil {
+		if x, ok := x.ReadersRef.(*Post_Readers); ok {
+			return x.Readers
+		}
+	}
+	return nil
+}
+
+type isPost_AuthorRef interface {
+	isPost_AuthorRef()
+}
+
+type Post_AuthorId struct {
+	AuthorId string `protobuf:"bytes,2,opt,name=author_id,json=authorId,proto3,oneof"`
+}
+
+type Post_Author struct {
+	Author *User `protobuf:"bytes,3,opt,name=author,proto3,oneof"`
+}
+
+func (*Post_AuthorId) isPost_AuthorRef() {}
+
+func (*Post_Author) isPost_AuthorRef() {}
+
+type isPost_EditorsRef interface {
+	isPost_EditorsRef()
+}
+
+type Post_EditorIds struct {
+	EditorIds string `protobuf:"bytes,4,opt,name=editor_ids,json=editorIds,proto3,oneof"`
+}
+
+type Post_Editors struct {
+	Editors *Post_EditorsValues `protobuf:"bytes,5,opt,name=editors,proto3,oneof"`
+}
+
+func (*Post_EditorIds) isPost_EditorsRef() {}
+
+func (*Post_Editors) isPost_EditorsRef() {}
+
+type isPost_ReadersRef interface {
+	isPost_ReadersRef()
+}
+
+type Post_ReaderIds struct {
+	ReaderIds string `protobuf:"bytes,6,opt,name=reader_ids,json=readerIds,proto3,oneof"`
+}
+
+type Post_Readers struct {
+	Readers *Post_ReadersValues `protobuf:"bytes,7,opt,name=readers,proto3,oneof"`
+}
+
+func (*Post_ReaderIds) isPost_ReadersRef() {}
+
+func (*Post_Readers) isPost_ReadersRef() {}
+
+type User struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Status        *int32                 `protobuf:"varint,3,opt,name=status,proto3,oneof" json:"status,omitempty"`
+	Seen          *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=seen,proto3" json:"seen,omitempty"`
+	Tags          []string               `protobuf:"bytes,5,rep,name=tags,proto3" json:"tags,omitempty"`
+	Home          *Address               `protobuf:"bytes,6,opt,name=home,proto3" json:"home,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *User) Reset() {
+	*x = User{}
+	mi := &file_main_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *User) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*User) ProtoMessage() {}
+
+func (x *User) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use User.ProtoReflect.Descriptor instead.
+func (*User) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *User) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *User) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *User) GetStatus() int32 {
+	if x != nil && x.Status != nil {
+		return *x.Status
+	}
+	return 0
+}
+
+func (x *User) GetSeen() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Seen
+	}
+	return nil
+}
+
+func (x *User) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *User) GetHome() *Address {
+	if x != nil {
+		return x.Home
+	}
+	return nil
+}
+
+type Address struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	City          string                 `protobuf:"bytes,1,opt,name=city,proto3" json:"city,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Address) Reset() {
+	*x = Address{}
+	mi := &file_main_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Address) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Address) ProtoMessage() {}
+
+func (x *Address) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Address.ProtoReflect.Descriptor instead.
+func (*Address) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Address) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+type Post_EditorsValues struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Values        []*User                `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Post_EditorsValues) Reset() {
+	*x = Post_EditorsValues{}
+	mi := &file_main_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Post_EditorsValues) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Post_EditorsValues) ProtoMessage() {}
+
+func (x *Post_EditorsValues) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Post_EditorsValues.ProtoReflect.Descriptor instead.
+func (*Post_EditorsValues) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{0, 0}
+}
+
+func (x *Post_EditorsValues) GetValues() []*User {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type Post_ReadersValues struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Values        []*User                `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Post_ReadersValues) Reset() {
+	*x = Post_ReadersValues{}
+	mi := &file_main_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Post_ReadersValues) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Post_ReadersValues) ProtoMessage() {}
+
+func (x *Post_ReadersValues) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Post_ReadersValues.ProtoReflect.Descriptor instead.
+func (*Post_ReadersValues) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{0, 1}
+}
+
+func (x *Post_ReadersValues) GetValues() []*User {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+var File_main_proto protoreflect.FileDescriptor
+
+const file_main_proto_rawDesc = "" +
+	"\n" +
+	"\n" +
+	"main.proto\x12\x04main\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa5\x03\n" +
+	"\x04Post\x12\x14\n" +
+	"\x05title\x18\x01 \x01(\tR\x05title\x12\x1d\n" +
+	"\tauthor_id\x18\x02 \x01(\tH\x00R\bauthorId\x12$\n" +
+	"\x06author\x18\x03 \x01(\v2\n" +
+	".main.UserH\x00R\x06author\x12\x1f\n" +
+	"\n" +
+	"editor_ids\x18\x04 \x01(\tH\x01R\teditorIds\x124\n" +
+	"\aeditors\x18\x05 \x01(\v2\x18.main.Post.EditorsValuesH\x01R\aeditors\x12\x1f\n" +
+	"\n" +
+	"reader_ids\x18\x06 \x01(\tH\x02R\treaderIds\x124\n" +
+	"\areaders\x18\a \x01(\v2\x18.main.Post.ReadersValuesH\x02R\areaders\x1a3\n" +
+	"\rEditorsValues\x12\"\n" +
+	"\x06values\x18\x01 \x03(\v2\n" +
+	".main.UserR\x06values\x1a3\n" +
+	"\rReadersValues\x12\"\n" +
+	"\x06values\x18\x01 \x03(\v2\n" +
+	".main.UserR\x06valuesB\f\n" +
+	"\n" +
+	"author_refB\r\n" +
+	"\veditors_refB\r\n" +
+	"\vreaders_ref\"\xb9\x01\n" +
+	"\x04User\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1b\n" +
+	"\x06status\x18\x03 \x01(\x05H\x00R\x06status\x88\x01\x01\x12.\n" +
+	"\x04seen\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x04seen\x12\x12\n" +
+	"\x04tags\x18\x05 \x03(\tR\x04tags\x12!\n" +
+	"\x04home\x18\x06 \x01(\v2\r.main.AddressR\x04homeB\t\n" +
+	"\a_status\"\x1d\n" +
+	"\aAddress\x12\x12\n" +
+	"\x04city\x18\x01 \x01(\tR\x04cityB\x13Z\x11fixtures/proto/pbb\x06proto3"
+
+var (
+	file_main_proto_rawDescOnce sync.Once
+	file_main_proto_rawDescData []byte
+)
+
+func file_main_proto_rawDescGZIP() []byte {
+	file_main_proto_rawDescOnce.Do(func() {
+		file_main_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_main_proto_rawDesc), len(file_main_proto_rawDesc)))
+	})
+	return file_main_proto_rawDescData
+}
+
+var file_main_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_main_proto_goTypes = []any{
+	(*Post)(nil),                  // 0: main.Post
+	(*User)(nil),                  // 1: main.User
+	(*Address)(nil),               // 2: main.Address
+	(*Post_EditorsValues)(nil),    // 3: main.Post.EditorsValues
+	(*Post_ReadersValues)(nil),    // 4: main.Post.ReadersValues
+	(*timestamppb.Timestamp)(nil), // 5: google.protobuf.Timestamp
+}
+var file_main_proto_depIdxs = []int32{
+	1, // 0: main.Post.author:type_name -> main.User
+	3, // 1: main.Post.editors:type_name -> main.Post.EditorsValues
+	4, // 2: main.Post.readers:type_name -> main.Post.ReadersValues
+	5, // 3: main.User.seen:type_name -> google.protobuf.Timestamp
+	2, // 4: main.User.home:type_name -> main.Address
+	1, // 5: main.Post.EditorsValues.values:type_name -> main.User
+	1, // 6: main.Post.ReadersValues.values:type_name -> main.User
+	7, // [7:7] is the sub-list for method output_type
+	7, // [7:7] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_main_proto_init() }
+func file_main_proto_init() {
+	if File_main_proto != nil {
+		return
+	}
+	file_main_proto_msgTypes[0].OneofWrappers = []any{
+		(*Post_AuthorId)(nil),
+		(*Post_Author)(nil),
+		(*Post_EditorIds)(nil),
+		(*Post_Editors)(nil),
+		(*Post_ReaderIds)(nil),
+		(*Post_Readers)(nil),
+	}
+	file_main_proto_msgTypes[1].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_main_proto_rawDesc), len(file_main_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_main_proto_goTypes,
+		DependencyIndexes: file_main_proto_depIdxs,
+		MessageInfos:      file_main_proto_msgTypes,
+	}.Build()
+	File_main_proto = out.File
+	file_main_proto_goTypes = nil
+	file_main_proto_depIdxs = nil
+}
@@ -0,0 +1,101 @@
+// +build ignore
+
+package main
+
+import (
+  "time"
+  "testing"
+  "fixtures/proto/pb"
+  "google.golang.org/protobuf/proto"
+  "github.com/stretchr/testify/assert"
+)
+
+type Status int8
+
+type Address struct {
+  City    string            `json:"city"`
+}
+
+type User struct {
+  Id      string            `json:"id"`
+  Name    string            `json:"name"`
+  Status  *Status           `json:"status,omitempty"`
+  Seen    time.Time         `json:"seen"`
+  Tags    []string          `json:"tags,omitempty"`
+  Home    Address           `json:"home"`
+}
+
+type Post struct {
+  Title   string            `json:"title"`
+  Author  *User             `json:"author" ref:"author_id"`
+  Editors []*User           `json:"editors,omitempty" ref:"editor_ids,value"`
+  Readers []User            `json:"readers,omitempty" ref:"reader_ids"`
+}
+
+/**
+ * Convert a post to its message, through the wire, and back
+ */
+func roundTrip(t *testing.T, p *Post) (*pb.Post, *Post) {
+  data, err := proto.Marshal(PostToProto(p))
+  if !assert.Nil(t, err) {
+    return nil, nil
+  }
+  m := &pb.Post{}
+  if !assert.Nil(t, proto.Unmarshal(data, m)) {
+    return nil, nil
+  }
+  return m, PostFromProto(m)
+}
+
+func TestProtoValues(t *testing.T) {
+  s := Status(2)
+  a := &User{Id:"a", Name:"Alice", Status:&s, Seen:time.Unix(1700000000, 0).UTC(), Tags:[]string{"admin"}, Home:Address{City:"Oslo"}}
+  b := &User{Id:"b", Name:"Bob", Seen:time.Unix(1700000100, 0).UTC()}
+  
+  m, p := roundTrip(t, &Post{Title:"Hello", Author:NewUserRef(a), Editors:NewArrayOfPtrToUserRef([]*User{a, b}), Readers:NewArrayOfUserRef([]User{*b})})
+  if p == nil {
+    return
+  }
+  assert.Equal(t, "a", m.GetAuthor().GetId())
+  assert.Len(t, m.GetEditors().GetValues(), 2)
+  assert.Equal(t, "Hello", p.Title)
+  if assert.True(t, p.Author.HasValue()) {
+    assert.Equal(t, a, p.Author.Value)
+  }
+  if assert.True(t, p.Editors.HasValue()) {
+    assert.Equal(t, []*User{a, b}, p.Editors.Value)
+  }
+  if assert.True(t, p.Readers.HasValue()) {
+    assert.Equal(t, []User{*b}, p.Readers.Value)
+  }
+}
+
+func TestProtoIds(t *testing.T) {
+  m, p := roundTrip(t, &Post{Title:"Hello", Author:NewUserRefId("a"), Editors:NewArrayOfPtrToUserRefId("e"), Readers:NewArrayOfUserRefId("r")})
+  if p == nil {
+    return
+  }
+  assert.Equal(t, "a", m.GetAuthorId())
+  assert.Equal(t, "e", m.GetEditorIds())
+  assert.Equal(t, "r", m.GetReaderIds())
+  for _, e := range []struct{ Has bool; Id, Expect string }{
+    {p.Author.HasValue(), p.Author.Id, "a"},
+    {p.Editors.HasValue(), p.Editors.Id, "e"},
+    {p.Readers.HasValue(), p.Readers.Id, "r"},
+  } {
+    assert.False(t, e.Has)
+    assert.Equal(t, e.Expect, e.Id)
+  }
+}
+
+func TestProtoNilRefs(t *testing.T) {
+  m, p := roundTrip(t, &Post{Title:"Hello"})
+  if p == nil {
+    return
+  }
+  assert.Nil(t, m.GetAuthorRef())
+  assert.Nil(t, m.GetEditorsRef())
+  assert.Nil(t, p.Author)
+  assert.Nil(t, p.Editors)
+  assert.Nil(t, p.Readers)
+}
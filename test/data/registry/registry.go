@@ -0,0 +1,63 @@
+// +build ignore
+
+package main
+
+import (
+  "strings"
+  "reflect"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+type User struct {
+  Id   string             `json:"id"`
+  Name string             `json:"name"`
+}
+
+type Hello struct {
+  Message string          `json:"message"`
+  Author  *User           `json:"author" ref:"author_id"`
+  Editor  *User           `json:"editor,omitempty" ref:"editor_id,value"`
+  Meta    struct {
+    Owner *User           `json:"owner" ref:"owner_id"`
+  }                       `json:"meta"`
+}
+
+type Plain struct {
+  Message string          `json:"message"`
+}
+
+func TestRegistry(t *testing.T) {
+  assert.Equal(t, []string{"Hello"}, RefStructs())
+  assert.Equal(t, []RefField{
+    {Name:"Author", Type:"*UserRef", Referenced:"*User", IdKey:"author_id", ValueKey:"author", Variant:"id"},
+    {Name:"Editor", Type:"*UserRef", Referenced:"*User", IdKey:"editor_id", ValueKey:"editor", Variant:"value", OmitEmpty:true},
+    {Name:"Meta.Owner", Type:"*UserRef", Referenced:"*User", IdKey:"owner_id", ValueKey:"owner", Variant:"id"},
+  }, RefFieldsOf("Hello"))
+  assert.Nil(t, RefFieldsOf("Plain"))
+}
+
+func TestRegistryDescribesFields(t *testing.T) {
+  types := map[string]reflect.Type{"Hello": reflect.TypeOf(Hello{})}
+  for _, s := range RefStructs() {
+    for _, e := range RefFieldsOf(s) {
+      var f reflect.StructField
+      v, ok := types[s], true
+      for _, n := range strings.Split(e.Name, ".") {
+        f, ok = v.FieldByName(n)
+        if !assert.True(t, ok, "No such field: %v.%v", s, e.Name) {
+          break
+        }
+        v = f.Type
+      }
+      if ok {
+        assert.Equal(t, e.Type, strings.Replace(f.Type.String(), "main.", "", 1), e.Name)
+      }
+    }
+  }
+  
+  // callers get a copy they can't corrupt the registry through
+  f := RefFieldsOf("Hello")
+  f[0].Name = "Changed"
+  assert.Equal(t, "Author", RefFieldsOf("Hello")[0].Name)
+}
@@ -0,0 +1,173 @@
+// +build ignore
+
+package main
+
+import (
+  "io"
+  "fmt"
+  "errors"
+  "context"
+  "testing"
+  "database/sql"
+  "database/sql/driver"
+  "github.com/stretchr/testify/assert"
+)
+
+type User struct {
+  ID    int64             `json:"id"`
+  Name  string            `json:"name" db:"user_name"`
+  Email *string           `json:"email"`
+}
+
+type Post struct {
+  Title  string           `json:"title"`
+  Author *User            `json:"author" ref:"author_id,table=users"`
+}
+
+/**
+ * A database of users that records the queries made of it. Rows are keyed
+ * by id and hold the id, user_name and email columns.
+ */
+type fakeDB struct {
+  Rows    map[string][]driver.Value
+  Queries []fakeQuery
+}
+
+type fakeQuery struct {
+  SQL   string
+  Args  []interface{}
+}
+
+func (d *fakeDB) Connect(cxt context.Context) (driver.Conn, error) {
+  return fakeConn{d}, nil
+}
+
+func (d *fakeDB) Driver() driver.Driver {
+  return nil
+}
+
+type fakeConn struct {
+  db *fakeDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+  return nil, errors.New("Statements are not supported")
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+  return nil, errors.New("Transactions are not supported")
+}
+
+func (c fakeConn) Close() error {
+  return nil
+}
+
+func (c fakeConn) QueryContext(cxt context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+  q := fakeQuery{SQL:query}
+  rows := &fakeRows{}
+  for _, e := range args {
+    q.Args = append(q.Args, e.Value)
+    if r, ok := c.db.Rows[fmt.Sprint(e.Value)]; ok {
+      rows.Rows = append(rows.Rows, r)
+    }
+  }
+  c.db.Queries = append(c.db.Queries, q)
+  return rows, nil
+}
+
+type fakeRows struct {
+  Rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+  return []string{"id", "user_name", "email"}
+}
+
+func (r *fakeRows) Close() error {
+  return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+  if len(r.Rows) < 1 {
+    return io.EOF
+  }
+  copy(dest, r.Rows[0])
+  r.Rows = r.Rows[1:]
+  return nil
+}
+
+func newFakeDB() (*fakeDB, *sql.DB) {
+  d := &fakeDB{Rows:map[string][]driver.Value{
+    "1": {int64(1), []byte("Alice"), []byte("alice@example.com")},
+    "2": {int64(2), []byte("Bob"), nil},
+    "3": {int64(3), []byte("Carol"), nil},
+    "4": {int64(4), nil, nil},
+  }}
+  return d, sql.OpenDB(d)
+}
+
+func TestResolveBatches(t *testing.T) {
+  d, db := newFakeDB()
+  defer db.Close()
+  r := NewUserRefResolver(db)
+  r.Numbered = true
+  r.BatchSize = 2
+  
+  a, b := NewUserRefId("1"), NewUserRefId("1")
+  c, e := NewUserRefId("2"), NewUserRefId("3")
+  v := NewUserRef(&User{ID:5})
+  err := r.Resolve(context.Background(), a, nil, b, c, v, e)
+  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    return
+  }
+  
+  // each distinct id is queried once, no more than two at a time
+  assert.Equal(t, []fakeQuery{
+    {"SELECT id, user_name, email FROM users WHERE id IN ($1, $2)", []interface{}{"1", "2"}},
+    {"SELECT id, user_name, email FROM users WHERE id IN ($1)", []interface{}{"3"}},
+  }, d.Queries)
+  
+  email := "alice@example.com"
+  assert.Equal(t, &User{ID:1, Name:"Alice", Email:&email}, a.Value)
+  assert.True(t, a.Value == b.Value, "Refs to the same id don't share a value")
+  assert.Equal(t, &User{ID:2, Name:"Bob"}, c.Value) // NULL is a nil pointer
+  assert.Equal(t, &User{ID:3, Name:"Carol"}, e.Value)
+  assert.Equal(t, &User{ID:5}, v.Value)
+}
+
+func TestResolvePlaceholders(t *testing.T) {
+  d, db := newFakeDB()
+  defer db.Close()
+  
+  err := NewUserRefResolver(db).Resolve(context.Background(), NewUserRefId("1"), NewUserRefId("2"))
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) && assert.Len(t, d.Queries, 1) {
+    assert.Equal(t, "SELECT id, user_name, email FROM users WHERE id IN (?, ?)", d.Queries[0].SQL)
+  }
+}
+
+func TestResolveMissing(t *testing.T) {
+  _, db := newFakeDB()
+  defer db.Close()
+  r := NewUserRefResolver(db)
+  
+  a, b := NewUserRefId("1"), NewUserRefId("9")
+  err := r.Resolve(context.Background(), a, b)
+  if assert.Nil(t, err, fmt.Sprintf("%v", err)) {
+    assert.NotNil(t, a.Value)
+    assert.Nil(t, b.Value) // no row, no value
+  }
+  
+  _, err = r.Loader()(context.Background(), "9")
+  assert.True(t, errors.Is(err, sql.ErrNoRows), fmt.Sprintf("%v", err))
+}
+
+func TestResolveNull(t *testing.T) {
+  _, db := newFakeDB()
+  defer db.Close()
+  
+  // NULL can't be scanned into a field that can't hold it
+  a := NewUserRefId("4")
+  err := NewUserRefResolver(db).Resolve(context.Background(), a)
+  assert.NotNil(t, err)
+  assert.Nil(t, a.Value)
+}